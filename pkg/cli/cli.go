@@ -1,10 +1,13 @@
 package cli
 
 import (
+	"errors"
 	"github.com/ThunderAl197/kubedump/pkg/manifests"
+	"github.com/ThunderAl197/kubedump/pkg/metrics"
+	"github.com/ThunderAl197/kubedump/pkg/report"
 	"github.com/ThunderAl197/kubedump/pkg/volumes"
 	"github.com/urfave/cli"
-	"log"
+	"log/slog"
 	"os"
 )
 
@@ -12,13 +15,51 @@ func RunCli() {
 	app := &cli.App{
 		Name:        "kubedump",
 		Description: "Kubernetes cluster backup tool",
-		Commands: []cli.Command{
+		Flags:       append(getLoggingFlags(), getMetricsFlags()...),
+		Before: func(c *cli.Context) error {
+			err := setupLogging(c)
+			if err != nil {
+				return err
+			}
+
+			return setupMetrics(c)
+		},
+		Commands: trackCommands([]cli.Command{
 			manifests.GetCliCommand(),
 			volumes.GetCliCommand(),
-		},
+			{
+				Name:  "check",
+				Usage: "Check permissions required by other commands",
+				Subcommands: []cli.Command{
+					manifests.GetCheckCliCommand(),
+					volumes.GetCheckCliCommand(),
+				},
+			},
+			manifests.GetValidateCliCommand(),
+			{
+				Name:  "restore",
+				Usage: "Restore data from dump",
+				Subcommands: []cli.Command{
+					volumes.GetRestoreCliCommand(),
+				},
+			},
+			volumes.GetCleanupCliCommand(),
+		}),
 	}
 
-	if err := app.Run(os.Args); err != nil {
-		log.Fatal(err)
+	err := app.Run(os.Args)
+
+	if metricsErr := metrics.Finish(err); metricsErr != nil {
+		slog.Error("Cannot export metrics", "error", metricsErr)
+	}
+
+	if err != nil {
+		if errors.Is(err, report.ErrPartial) {
+			slog.Warn(err.Error())
+			os.Exit(report.ExitCodePartial)
+		}
+
+		slog.Error(err.Error())
+		os.Exit(1)
 	}
 }
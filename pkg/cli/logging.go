@@ -0,0 +1,68 @@
+package cli
+
+import (
+	"fmt"
+	"github.com/ThunderAl197/kubedump/pkg/progress"
+	"github.com/urfave/cli"
+	"log/slog"
+	"strings"
+)
+
+func getLoggingFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:   "log-format",
+			EnvVar: "KUBEDUMP_LOG_FORMAT",
+			Usage:  "Log format: text or json",
+			Value:  "text",
+		},
+		cli.StringFlag{
+			Name:   "log-level",
+			EnvVar: "KUBEDUMP_LOG_LEVEL",
+			Usage:  "Log level: debug, info, warn or error",
+			Value:  "info",
+		},
+		cli.BoolFlag{
+			Name:  "quiet,q",
+			Usage: "Log warnings and errors only",
+		},
+		cli.BoolFlag{
+			Name:  "verbose,v",
+			Usage: "Log debug messages",
+		},
+	}
+}
+
+func setupLogging(c *cli.Context) error {
+	var level slog.Level
+
+	err := level.UnmarshalText([]byte(c.String("log-level")))
+	if err != nil {
+		return fmt.Errorf("invalid log level %q", c.String("log-level"))
+	}
+
+	if c.Bool("quiet") {
+		level = slog.LevelWarn
+	}
+
+	if c.Bool("verbose") {
+		level = slog.LevelDebug
+	}
+
+	options := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+
+	switch strings.ToLower(c.String("log-format")) {
+	case "text":
+		handler = slog.NewTextHandler(progress.Stderr, options)
+	case "json":
+		handler = slog.NewJSONHandler(progress.Stderr, options)
+	default:
+		return fmt.Errorf("invalid log format %q", c.String("log-format"))
+	}
+
+	slog.SetDefault(slog.New(handler))
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package cli
+
+import (
+	"github.com/ThunderAl197/kubedump/pkg/metrics"
+	"github.com/urfave/cli"
+)
+
+func getMetricsFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:   "metrics-addr",
+			EnvVar: "KUBEDUMP_METRICS_ADDR",
+			Usage:  "Serve prometheus metrics on this address during the run, e.g. :9090",
+		},
+		cli.StringFlag{
+			Name:   "pushgateway-url",
+			EnvVar: "KUBEDUMP_PUSHGATEWAY_URL",
+			Usage:  "Push metrics to prometheus pushgateway at completion",
+		},
+		cli.StringFlag{
+			Name:   "metrics-job",
+			EnvVar: "KUBEDUMP_METRICS_JOB",
+			Usage:  "Job name used for pushgateway",
+			Value:  "kubedump",
+		},
+		cli.StringFlag{
+			Name:   "metrics-textfile",
+			EnvVar: "KUBEDUMP_METRICS_TEXTFILE",
+			Usage:  "Write metrics at completion to this file for node exporter textfile collector",
+		},
+	}
+}
+
+func setupMetrics(c *cli.Context) error {
+	return metrics.Start(metrics.Config{
+		ListenAddr:     c.String("metrics-addr"),
+		PushgatewayUrl: c.String("pushgateway-url"),
+		Job:            c.String("metrics-job"),
+		Textfile:       c.String("metrics-textfile"),
+	})
+}
+
+// trackCommands sets metrics command label to full name of the running command, so subcommands
+// like "check manifests" and "check volumes" are told apart
+func trackCommands(commands []cli.Command) []cli.Command {
+	for i := range commands {
+		commands[i].Subcommands = trackCommands(commands[i].Subcommands)
+
+		action, ok := commands[i].Action.(func(*cli.Context) error)
+		if !ok {
+			continue
+		}
+
+		commands[i].Action = func(c *cli.Context) error {
+			metrics.SetCommand(c.Command.FullName())
+			return action(c)
+		}
+	}
+
+	return commands
+}
@@ -0,0 +1,214 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"github.com/ThunderAl197/kubedump/pkg/report"
+	"golang.org/x/sync/errgroup"
+	"io"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strings"
+)
+
+// AccessCheck describes a single permission kubedump needs. Empty Namespace means cluster-wide
+type AccessCheck struct {
+	Verb        string
+	Group       string
+	Resource    string
+	Subresource string
+	Namespace   string
+}
+
+// FullResource returns resource in resource.group/subresource form
+func (a AccessCheck) FullResource() string {
+	resource := a.Resource
+	if a.Group != "" {
+		resource = resource + "." + a.Group
+	}
+	if a.Subresource != "" {
+		resource = resource + "/" + a.Subresource
+	}
+
+	return resource
+}
+
+func (a AccessCheck) String() string {
+	namespace := a.Namespace
+	if namespace == "" {
+		namespace = "all namespaces"
+	}
+
+	return fmt.Sprintf("%s %s in %s", a.Verb, a.FullResource(), namespace)
+}
+
+type AccessResult struct {
+	Check   AccessCheck
+	Allowed bool
+	Reason  string
+}
+
+// accessCheckConcurrency limits access reviews sent at once
+const accessCheckConcurrency = 16
+
+// CheckAccess asks the api server whether current user is allowed to perform given checks.
+// Reviews are sent concurrently, results are in order of checks
+func CheckAccess(ctx context.Context, checks []AccessCheck) ([]AccessResult, error) {
+	results := make([]AccessResult, len(checks))
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(accessCheckConcurrency)
+
+	for i, check := range checks {
+		i, check := i, check
+
+		g.Go(func() error {
+			review := &authorizationv1.SelfSubjectAccessReview{
+				Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+					ResourceAttributes: &authorizationv1.ResourceAttributes{
+						Namespace:   check.Namespace,
+						Verb:        check.Verb,
+						Group:       check.Group,
+						Resource:    check.Resource,
+						Subresource: check.Subresource,
+					},
+				},
+			}
+
+			review, err := KClient.AuthorizationV1().
+				SelfSubjectAccessReviews().
+				Create(ctx, review, metav1.CreateOptions{})
+			if err != nil {
+				return err
+			}
+
+			results[i] = AccessResult{
+				Check:   check,
+				Allowed: review.Status.Allowed,
+				Reason:  review.Status.Reason,
+			}
+
+			return nil
+		})
+	}
+
+	err := g.Wait()
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// BuildClusterRole generates minimal ClusterRole which grants all given checks
+func BuildClusterRole(name string, checks []AccessCheck) *rbacv1.ClusterRole {
+	type ruleKey struct {
+		group    string
+		resource string
+	}
+
+	verbs := make(map[ruleKey]map[string]bool)
+	for _, check := range checks {
+		resource := check.Resource
+		if check.Subresource != "" {
+			resource = resource + "/" + check.Subresource
+		}
+
+		key := ruleKey{check.Group, resource}
+		if verbs[key] == nil {
+			verbs[key] = make(map[string]bool)
+		}
+		verbs[key][check.Verb] = true
+	}
+
+	// merge resources of the same group having the same verbs into one rule
+	rules := make(map[string]*rbacv1.PolicyRule)
+	for key, verbSet := range verbs {
+		var ruleVerbs []string
+		for verb := range verbSet {
+			ruleVerbs = append(ruleVerbs, verb)
+		}
+		sort.Strings(ruleVerbs)
+
+		id := key.group + "|" + strings.Join(ruleVerbs, ",")
+		rule, ok := rules[id]
+		if !ok {
+			rule = &rbacv1.PolicyRule{
+				APIGroups: []string{key.group},
+				Verbs:     ruleVerbs,
+			}
+			rules[id] = rule
+		}
+
+		rule.Resources = append(rule.Resources, key.resource)
+	}
+
+	var ids []string
+	for id := range rules {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	role := &rbacv1.ClusterRole{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "rbac.authorization.k8s.io/v1",
+			Kind:       "ClusterRole",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+	}
+
+	for _, id := range ids {
+		rule := rules[id]
+		sort.Strings(rule.Resources)
+		role.Rules = append(role.Rules, *rule)
+	}
+
+	return role
+}
+
+func ClusterRoleYaml(name string, checks []AccessCheck) ([]byte, error) {
+	return yaml.Marshal(BuildClusterRole(name, checks))
+}
+
+// ReportAccess prints denied checks and returns an error if any of them is denied
+func ReportAccess(w io.Writer, results []AccessResult) error {
+	table := report.NewTable("VERB", "RESOURCE", "NAMESPACE", "REASON")
+	denied := 0
+
+	for _, result := range results {
+		if result.Allowed {
+			continue
+		}
+
+		denied++
+
+		namespace := result.Check.Namespace
+		if namespace == "" {
+			namespace = "*"
+		}
+
+		reason := result.Reason
+		if reason == "" {
+			reason = "-"
+		}
+
+		table.Add(result.Check.Verb, result.Check.FullResource(), namespace, reason)
+	}
+
+	if denied == 0 {
+		_, err := fmt.Fprintf(w, "All %d permission checks passed\n", len(results))
+		return err
+	}
+
+	err := table.Print(w)
+	if err != nil {
+		return err
+	}
+
+	return fmt.Errorf("%d of %d permission checks denied", denied, len(results))
+}
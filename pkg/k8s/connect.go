@@ -11,7 +11,7 @@ import (
 var (
 	KConfig    *rest.Config
 	KClient    *kubernetes.Clientset
-	KDynClient *dynamic.DynamicClient
+	KDynClient dynamic.Interface
 )
 
 func InitClient(kubeconfig string) error {
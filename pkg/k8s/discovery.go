@@ -0,0 +1,28 @@
+package k8s
+
+import (
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/disk"
+	"path"
+	"regexp"
+	"time"
+)
+
+// DiscoveryClient returns discovery client caching results in cacheDir for ttl.
+// Empty cacheDir disables caching
+func DiscoveryClient(cacheDir string, ttl time.Duration) (discovery.DiscoveryInterface, error) {
+	if cacheDir == "" {
+		return KClient.Discovery(), nil
+	}
+
+	// separate cache for every cluster
+	hostDir := regexp.MustCompile("[^A-Za-z0-9._-]").ReplaceAllString(KConfig.Host, "_")
+	cacheDir = path.Join(cacheDir, hostDir)
+
+	return disk.NewCachedDiscoveryClientForConfig(
+		KConfig,
+		path.Join(cacheDir, "discovery"),
+		path.Join(cacheDir, "http"),
+		ttl,
+	)
+}
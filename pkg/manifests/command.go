@@ -1,9 +1,12 @@
 package manifests
 
 import (
+	"github.com/ThunderAl197/kubedump/pkg/output"
+	"github.com/ThunderAl197/kubedump/pkg/progress"
 	"github.com/urfave/cli"
 	"k8s.io/client-go/util/homedir"
 	"path"
+	"time"
 )
 
 type CommandArgs struct {
@@ -13,72 +16,193 @@ type CommandArgs struct {
 	OnlyNamespaces    []string
 	ExcludeNamespaces []string
 	NoNonNamespaced   bool
+	OnlyClusterScoped bool
 	OnlyResources     []string
 	ExcludeResources  []string
 	DryRun            bool
+	FailFast          bool
+	VerifyConsistency bool
+	SkipPreflight     bool
+	OnlyApiGroups     []string
+	ExcludeApiGroups  []string
+	DiscoveryCacheDir string
+	DiscoveryCacheTTL time.Duration
+	CrdVersions       string
+	ExtractData       bool
+	HelmReleases      bool
+	Layout            string
+	Clean             bool
+	TransformFile     string
+	SaveOpenApi       bool
+	Progress          string
+	// RelatedClusterObjects limits cluster-scoped objects to ones related to dumped namespaces
+	RelatedClusterObjects bool
+	Output                output.Config
+
+	output output.Backend
+}
+
+func getCliFlags() []cli.Flag {
+	return append([]cli.Flag{
+		cli.StringFlag{
+			Name:   "kubeconfig",
+			EnvVar: "KUBECONFIG",
+			Usage:  "Path to kubeconfig file",
+			Value:  path.Join(homedir.HomeDir(), ".kube", "config"),
+		},
+		cli.StringFlag{
+			Name:  "output,o",
+			Usage: "Path to dist directory or s3://bucket/prefix",
+			Value: "./out",
+		},
+		cli.StringFlag{
+			Name:  "template,t",
+			Usage: "File name template. Available patterns: {namespace}, {kind}, {resource}, {version}, {name}. Non-namespaced will have _cluster in {namespace}",
+			Value: "manifests/{namespace}/{resource}/{name}.yaml",
+		},
+		cli.StringFlag{
+			Name:  "layout",
+			Usage: "Output layout: default or kustomize. Kustomize layout overrides --template, implies --clean and generates kustomization.yaml files",
+			Value: LayoutDefault,
+		},
+		cli.BoolFlag{
+			Name:  "clean",
+			Usage: "Remove server populated fields (uid, resourceVersion, status, etc.) from manifests",
+		},
+		cli.StringFlag{
+			Name:  "transform",
+			Usage: "Path to yaml file with transformation rules (jsonPath selectors, jsonPatch, strategicMerge) applied to objects before writing",
+		},
+		cli.StringSliceFlag{
+			Name:  "namespaces,n",
+			Usage: "Load specific namespaces. By default all",
+		},
+		cli.StringSliceFlag{
+			Name:  "exclude-namespaces,N",
+			Usage: "Load other except this namespaces. Can work with --namespaces",
+		},
+		cli.StringSliceFlag{
+			Name:  "resources,r",
+			Usage: "Load specific namespaces. By default all (see --exclude-resources)",
+		},
+		cli.StringSliceFlag{
+			Name:  "exclude-resources,R",
+			Usage: "Load other except this namespaces. Can work with --resource. By default: events",
+			Value: &cli.StringSlice{"events", "componentstatuses"},
+		},
+		cli.StringSliceFlag{
+			Name:  "api-groups",
+			Usage: "Load resources of specific api groups only. Use \"core\" for the legacy group. By default all",
+		},
+		cli.StringSliceFlag{
+			Name:  "exclude-api-groups",
+			Usage: "Load other except this api groups. Can work with --api-groups",
+		},
+		cli.StringFlag{
+			Name:  "discovery-cache-dir",
+			Usage: "Directory to cache api discovery between runs. Empty value disables cache",
+			Value: path.Join(homedir.HomeDir(), ".kube", "cache", "kubedump"),
+		},
+		cli.DurationFlag{
+			Name:  "discovery-cache-ttl",
+			Usage: "How long cached api discovery is valid",
+			Value: 10 * time.Minute,
+		},
+		cli.StringFlag{
+			Name:  "crd-versions",
+			Usage: "Versions of custom resources to load: preferred, storage or all served. Extra versions are written to {resource}_{version} unless template has {version}",
+			Value: CrdVersionsPreferred,
+		},
+		cli.BoolFlag{
+			Name:  "related-cluster-objects",
+			Usage: "Load only non-namespaced objects related to loaded namespaces: namespaces, cluster role bindings and roles, volumes, storage classes and crds",
+		},
+		cli.BoolFlag{
+			Name:  "no-non-namespaced,G",
+			Usage: "Dont load non-namespaced resources",
+		},
+		cli.BoolFlag{
+			Name:  "only-cluster-scoped",
+			Usage: "Load only non-namespaced resources",
+		},
+		cli.BoolFlag{
+			Name:  "extract-data",
+			Usage: "Additionally write every configmap and secret key to its own file in a directory next to the manifest",
+		},
+		cli.BoolFlag{
+			Name:  "helm-releases",
+			Usage: "Decode helm release secrets into charts, values and manifests in helm/{namespace}/{release}",
+		},
+		cli.BoolFlag{
+			Name:  "save-openapi",
+			Usage: "Save cluster OpenAPI v3 documents to " + openApiDir + " for offline validation",
+		},
+		cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "Dont write files on disk",
+		},
+		cli.BoolFlag{
+			Name:  "fail-fast",
+			Usage: "Stop on first failed resource instead of skipping it",
+		},
+		cli.BoolFlag{
+			Name:  "verify-consistency",
+			Usage: "List all resources once more after dump and re-fetch objects changed during it",
+		},
+		cli.BoolFlag{
+			Name:  "skip-preflight",
+			Usage: "Dont check permissions before dumping",
+		},
+		cli.StringFlag{
+			Name:  "progress",
+			Usage: "Progress display: auto, bar, plain or none. Auto uses bars on terminal",
+			Value: progress.ModeAuto,
+		},
+	}, output.GetCliFlags()...)
+}
+
+func getCommandArgs(c *cli.Context) *CommandArgs {
+	return &CommandArgs{
+		Kubeconfig:        c.String("kubeconfig"),
+		OutputDir:         c.String("output"),
+		FileTemplate:      c.String("template"),
+		OnlyNamespaces:    c.StringSlice("namespaces"),
+		ExcludeNamespaces: c.StringSlice("exclude-namespaces"),
+		NoNonNamespaced:   c.Bool("no-non-namespaced"),
+		OnlyClusterScoped: c.Bool("only-cluster-scoped"),
+		OnlyResources:     c.StringSlice("resources"),
+		ExcludeResources:  c.StringSlice("exclude-resources"),
+		DryRun:            c.Bool("dry-run"),
+		FailFast:          c.Bool("fail-fast"),
+		VerifyConsistency: c.Bool("verify-consistency"),
+		SkipPreflight:     c.Bool("skip-preflight"),
+		OnlyApiGroups:     c.StringSlice("api-groups"),
+		ExcludeApiGroups:  c.StringSlice("exclude-api-groups"),
+		DiscoveryCacheDir: c.String("discovery-cache-dir"),
+		DiscoveryCacheTTL: c.Duration("discovery-cache-ttl"),
+		CrdVersions:       c.String("crd-versions"),
+		ExtractData:       c.Bool("extract-data"),
+		HelmReleases:      c.Bool("helm-releases"),
+		Layout:            c.String("layout"),
+		Clean:             c.Bool("clean"),
+		TransformFile:     c.String("transform"),
+		SaveOpenApi:       c.Bool("save-openapi"),
+		Progress:          c.String("progress"),
+
+		RelatedClusterObjects: c.Bool("related-cluster-objects"),
+		Output:                output.GetConfig(c),
+	}
 }
 
 func GetCliCommand() cli.Command {
 	return cli.Command{
 		Name:  "manifests",
 		Usage: "Download cluster manifests",
-		Flags: []cli.Flag{
-			cli.StringFlag{
-				Name:   "kubeconfig",
-				EnvVar: "KUBECONFIG",
-				Usage:  "Path to kubeconfig file",
-				Value:  path.Join(homedir.HomeDir(), ".kube", "config"),
-			},
-			cli.StringFlag{
-				Name:  "output,o",
-				Usage: "Path to dist directory",
-				Value: "./out",
-			},
-			cli.StringFlag{
-				Name:  "template,t",
-				Usage: "File name template. Available patterns: {namespace}, {kind}, {resource}, {name}. Non-namespaced will have _cluster in {namespace}",
-				Value: "manifests/{namespace}/{resource}/{name}.yaml",
-			},
-			cli.StringSliceFlag{
-				Name:  "namespaces,n",
-				Usage: "Load specific namespaces. By default all",
-			},
-			cli.StringSliceFlag{
-				Name:  "exclude-namespaces,N",
-				Usage: "Load other except this namespaces. Can work with --namespaces",
-			},
-			cli.StringSliceFlag{
-				Name:  "resources,r",
-				Usage: "Load specific namespaces. By default all (see --exclude-resources)",
-			},
-			cli.StringSliceFlag{
-				Name:  "exclude-resources,R",
-				Usage: "Load other except this namespaces. Can work with --resource. By default: events",
-				Value: &cli.StringSlice{"events", "componentstatuses"},
-			},
-			cli.BoolFlag{
-				Name:  "no-non-namespaced,G",
-				Usage: "Dont load non-namespaced resources",
-			},
-			cli.BoolFlag{
-				Name:  "dry-run",
-				Usage: "Dont write files on disk",
-			},
-		},
+		Flags: getCliFlags(),
 		Action: func(c *cli.Context) error {
 			var err error
 
-			err = Dump(&CommandArgs{
-				Kubeconfig:        c.String("kubeconfig"),
-				OutputDir:         c.String("output"),
-				FileTemplate:      c.String("template"),
-				OnlyNamespaces:    c.StringSlice("namespaces"),
-				ExcludeNamespaces: c.StringSlice("exclude-namespaces"),
-				NoNonNamespaced:   c.Bool("no-non-namespaced"),
-				OnlyResources:     c.StringSlice("resources"),
-				ExcludeResources:  c.StringSlice("exclude-resources"),
-				DryRun:            c.Bool("dry-run"),
-			})
+			err = Dump(getCommandArgs(c))
 			if err != nil {
 				return err
 			}
@@ -87,3 +211,19 @@ func GetCliCommand() cli.Command {
 		},
 	}
 }
+
+func GetCheckCliCommand() cli.Command {
+	return cli.Command{
+		Name:  "manifests",
+		Usage: "Check permissions required to download cluster manifests",
+		Flags: append(getCliFlags(),
+			cli.BoolFlag{
+				Name:  "cluster-role",
+				Usage: "Print minimal ClusterRole granting required permissions",
+			},
+		),
+		Action: func(c *cli.Context) error {
+			return Check(getCommandArgs(c), c.Bool("cluster-role"))
+		},
+	}
+}
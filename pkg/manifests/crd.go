@@ -0,0 +1,98 @@
+package manifests
+
+import (
+	"context"
+	"github.com/ThunderAl197/kubedump/pkg/k8s"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	CrdVersionsPreferred = "preferred"
+	CrdVersionsStorage   = "storage"
+	CrdVersionsAll       = "all"
+)
+
+var crdResource = schema.GroupVersionResource{
+	Group:    "apiextensions.k8s.io",
+	Version:  "v1",
+	Resource: "customresourcedefinitions",
+}
+
+type crdVersions struct {
+	storage string
+	served  []string
+}
+
+// discoverCrdVersions reads served and storage versions of every custom resource
+func discoverCrdVersions(ctx context.Context) (map[schema.GroupResource]crdVersions, error) {
+	list, err := k8s.KDynClient.
+		Resource(crdResource).
+		List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[schema.GroupResource]crdVersions)
+
+	for _, crd := range list.Items {
+		group, _, _ := unstructured.NestedString(crd.Object, "spec", "group")
+		plural, _, _ := unstructured.NestedString(crd.Object, "spec", "names", "plural")
+		versions, _, _ := unstructured.NestedSlice(crd.Object, "spec", "versions")
+
+		var info crdVersions
+
+		for _, v := range versions {
+			version, ok := v.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			name, _, _ := unstructured.NestedString(version, "name")
+			served, _, _ := unstructured.NestedBool(version, "served")
+			storage, _, _ := unstructured.NestedBool(version, "storage")
+
+			if served {
+				info.served = append(info.served, name)
+			}
+			if storage {
+				info.storage = name
+			}
+		}
+
+		result[schema.GroupResource{Group: group, Resource: plural}] = info
+	}
+
+	return result, nil
+}
+
+// crdResourceGroups returns versions of the custom resource to dump according to mode
+func crdResourceGroups(mode string, preferred ResourceGroup, info crdVersions) []ResourceGroup {
+	switch mode {
+	case CrdVersionsStorage:
+		if info.storage == "" {
+			return []ResourceGroup{preferred}
+		}
+
+		res := preferred
+		res.Version = info.storage
+		return []ResourceGroup{res}
+
+	case CrdVersionsAll:
+		groups := []ResourceGroup{preferred}
+		for _, version := range info.served {
+			if version == preferred.Version {
+				continue
+			}
+
+			res := preferred
+			res.Version = version
+			res.ExtraVersion = true
+			groups = append(groups, res)
+		}
+		return groups
+	}
+
+	return []ResourceGroup{preferred}
+}
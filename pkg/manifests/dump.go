@@ -2,175 +2,468 @@ package manifests
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/ThunderAl197/kubedump/pkg/k8s"
+	"github.com/ThunderAl197/kubedump/pkg/metrics"
+	"github.com/ThunderAl197/kubedump/pkg/output"
+	"github.com/ThunderAl197/kubedump/pkg/progress"
+	"github.com/ThunderAl197/kubedump/pkg/report"
 	"golang.org/x/sync/errgroup"
 	"gopkg.in/yaml.v2"
-	"log"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"log/slog"
 	"os"
-	"path"
+	"os/signal"
 	"regexp"
 	"strings"
+	"syscall"
 )
 
+var errConflictingScope = errors.New("--no-non-namespaced cannot be used with --only-cluster-scoped")
+
 func Dump(cfg *CommandArgs) error {
 	var err error
 
-	ctx := context.Background()
+	switch cfg.Layout {
+	case LayoutDefault:
+	case LayoutKustomize:
+		cfg.FileTemplate = kustomizeFileTemplate
+		cfg.Clean = true
+	default:
+		return fmt.Errorf("unknown layout %q", cfg.Layout)
+	}
+
+	if cfg.NoNonNamespaced && cfg.OnlyClusterScoped {
+		return errConflictingScope
+	}
+
+	if cfg.RelatedClusterObjects && (cfg.NoNonNamespaced || cfg.OnlyClusterScoped) {
+		return errors.New("--related-cluster-objects cannot be used with --no-non-namespaced or --only-cluster-scoped")
+	}
+
+	switch cfg.CrdVersions {
+	case CrdVersionsPreferred, CrdVersionsStorage, CrdVersionsAll:
+	default:
+		return fmt.Errorf("unknown crd versions mode %q", cfg.CrdVersions)
+	}
+
+	tracker, err := progress.New(cfg.Progress)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	err = k8s.InitClient(cfg.Kubeconfig)
 	if err != nil {
 		return err
 	}
 
-	var g errgroup.Group
+	if !cfg.DryRun {
+		cfg.output, err = output.New(ctx, cfg.OutputDir, cfg.Output)
+		if err != nil {
+			return err
+		}
+	}
 
-	groupsChannel := make(chan ResourceGroup)
+	// rules are loaded before any goroutine is started, so invalid file returns right away
+	var rules []*TransformRule
+	if cfg.TransformFile != "" {
+		rules, err = LoadTransformRules(cfg.TransformFile)
+		if err != nil {
+			return err
+		}
+	}
 
-	g.Go(func() error {
-		defer close(groupsChannel)
-		err := DiscoverGroups(ctx, groupsChannel)
+	processors := getPostProcessors(cfg)
+
+	var related *relatedObjects
+	if cfg.RelatedClusterObjects {
+		related = newRelatedObjects(cfg)
+	}
+
+	summary := NewSummary()
+	index := NewIndex()
+
+	groups, err := ListGroups(ctx, cfg, summary)
+	if err != nil {
 		return err
-	})
+	}
+
+	if !cfg.SkipPreflight {
+		allowed, err := preflightGroups(ctx, cfg, groups, summary)
+		if err != nil {
+			if cfg.FailFast {
+				return err
+			}
+
+			slog.Warn("Permissions preflight failed", "error", err)
+		} else {
+			groups = allowed
+		}
+	}
+
+	if cfg.SaveOpenApi {
+		err = saveOpenApi(cfg)
+		if err != nil {
+			return err
+		}
+	}
+
+	task := tracker.Task("resources", int64(len(groups)), progress.UnitCount)
+	task.Start()
+	tracker.Start()
+	defer tracker.Stop()
+
+	// failed writer cancels the producer, so it does not block on the full channel
+	g, gctx := errgroup.WithContext(ctx)
 
 	resourceChannel := make(chan ResourceAndGroup, 15)
 
 	g.Go(func() error {
 		defer close(resourceChannel)
 
-		for group := range groupsChannel {
-			if cfg.NoNonNamespaced && group.Namespaced {
-				continue
-			}
+		for _, group := range groups {
+			slog.Info("Loading resource", "resource", group.String())
 
-			resourceName := group.Resource
+			summary.Add(group.String(), 0)
 
-			if len(cfg.ExcludeResources) > 0 {
-				exclude := false
-				for _, rs := range cfg.ExcludeResources {
-					if rs == resourceName {
-						log.Printf("Skipping %s resource bacause of exclude option\n", resourceName)
-						exclude = true
-						break
-					}
-				}
-				if exclude {
-					continue
+			records, err := DiscoverResources(gctx, group, cfg.OnlyNamespaces, resourceChannel)
+			index.AddLists(records)
+			if err != nil {
+				if cfg.FailFast || gctx.Err() != nil {
+					return err
 				}
-			}
 
-			if len(cfg.OnlyResources) > 0 {
-				exclude := true
-				for _, rs := range cfg.OnlyResources {
-					if rs == resourceName {
-						exclude = false
-					}
-				}
-				if exclude {
-					log.Printf("Skipping %s resource bacause of inclusive option\n", resourceName)
-					continue
-				}
+				slog.Error("Cannot load resource", "resource", group.String(), "error", err)
+				summary.Fail(group.String(), err)
 			}
 
-			log.Printf("Loading %s resource\n", resourceName)
-
-			err := DiscoverResources(ctx, group, resourceChannel)
-			if err != nil {
-				return err
-			}
+			task.Add(1)
 		}
 
+		task.Finish()
+
 		return nil
 	})
 
 	g.Go(func() error {
 		for res := range resourceChannel {
-			resNs := res.resource.GetNamespace()
-
-			if len(cfg.ExcludeNamespaces) > 0 {
-				exclude := false
-				for _, ns := range cfg.ExcludeNamespaces {
-					if ns == resNs {
-						exclude = true
-						break
-					}
-				}
-				if exclude {
-					continue
-				}
+			// cluster-scoped objects are written once all namespaces are seen
+			if related != nil && !res.group.Namespaced {
+				related.Defer(res)
+				continue
 			}
 
-			if len(cfg.OnlyNamespaces) > 0 {
-				exclude := true
-				for _, ns := range cfg.OnlyNamespaces {
-					if ns == resNs {
-						exclude = false
-					}
-				}
-				if exclude {
-					continue
-				}
+			if !isNamespaceIncluded(cfg, res) {
+				continue
 			}
 
-			fileName := getResourceFilePath(cfg, res)
-			fileData, err := serializeObject(cfg, res)
-			if err != nil {
-				return err
+			if related != nil {
+				related.Observe(res)
 			}
 
-			if cfg.DryRun {
-				log.Printf("[dry run] %s %d bytes\n", fileName, len(fileData))
-			} else {
-				var err error
-				filePath := path.Join(cfg.OutputDir, fileName)
-
-				err = os.MkdirAll(path.Dir(filePath), 0700)
+			err := writeObject(cfg, res, rules, processors)
+			if err != nil {
+				err = skipFailedTransform(cfg, summary, res, err)
 				if err != nil {
 					return err
 				}
 
-				err = os.WriteFile(filePath, fileData, 0600)
+				continue
+			}
+
+			index.AddObject(res)
+			summary.Add(res.group.String(), 1)
+			metrics.ObjectsDumped.WithLabelValues(res.group.String()).Inc()
+		}
+
+		if related != nil {
+			for _, res := range related.Related() {
+				err := writeObject(cfg, res, rules, processors)
 				if err != nil {
-					return err
+					err = skipFailedTransform(cfg, summary, res, err)
+					if err != nil {
+						return err
+					}
+
+					continue
 				}
+
+				index.AddObject(res)
+				summary.Add(res.group.String(), 1)
+				metrics.ObjectsDumped.WithLabelValues(res.group.String()).Inc()
 			}
+		}
+
+		return nil
+	})
+
+	err = g.Wait()
+	if err != nil {
+		return err
+	}
+
+	if cfg.VerifyConsistency {
+		err = verifyConsistency(ctx, cfg, groups, index, summary, rules, processors)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, processor := range processors {
+		err := processor.Finish()
+		if err != nil {
+			return err
+		}
+	}
+
+	index.Finish()
+	slog.Info("Dump finished", "consistencyWindow", index.ConsistencyWindow)
+
+	err = index.Write(cfg)
+	if err != nil {
+		return err
+	}
 
+	fmt.Println()
+	err = summary.Print(os.Stdout)
+	if err != nil {
+		return err
+	}
+
+	if summary.HasErrors() {
+		return report.ErrPartial
+	}
+
+	return nil
+}
+
+// ListGroups discovers resources matching resource filters of the command
+func ListGroups(ctx context.Context, cfg *CommandArgs, summary *Summary) ([]ResourceGroup, error) {
+	var (
+		g      errgroup.Group
+		groups []ResourceGroup
+	)
+
+	groupsChannel := make(chan ResourceGroup)
+
+	g.Go(func() error {
+		defer close(groupsChannel)
+		err := DiscoverGroups(ctx, cfg, summary, groupsChannel)
+		return err
+	})
+
+	g.Go(func() error {
+		for group := range groupsChannel {
+			if isResourceIncluded(cfg, group) {
+				groups = append(groups, group)
+			}
 		}
 
 		return nil
 	})
 
-	return g.Wait()
+	err := g.Wait()
+	if err != nil {
+		return nil, err
+	}
+
+	return groups, nil
 }
 
-func getResourceFilePath(cfg *CommandArgs, res ResourceAndGroup) string {
-	filePath := cfg.FileTemplate
+// isScopeIncluded filters resources by --no-non-namespaced and --only-cluster-scoped options
+func isScopeIncluded(cfg *CommandArgs, group ResourceGroup) bool {
+	if cfg.NoNonNamespaced && !group.Namespaced {
+		return false
+	}
+
+	if cfg.OnlyClusterScoped && group.Namespaced {
+		return false
+	}
+
+	return true
+}
+
+func isResourceIncluded(cfg *CommandArgs, group ResourceGroup) bool {
+	if !isScopeIncluded(cfg, group) {
+		return false
+	}
 
-	namespace := res.resource.GetNamespace()
+	resourceName := group.Resource
 
+	if len(cfg.ExcludeResources) > 0 {
+		for _, rs := range cfg.ExcludeResources {
+			if rs == resourceName {
+				slog.Debug("Skipping resource because of exclude option", "resource", group.String())
+				return false
+			}
+		}
+	}
+
+	if len(cfg.OnlyResources) > 0 {
+		exclude := true
+		for _, rs := range cfg.OnlyResources {
+			if rs == resourceName {
+				exclude = false
+			}
+		}
+		if exclude {
+			slog.Debug("Skipping resource because of inclusive option", "resource", group.String())
+			return false
+		}
+	}
+
+	return true
+}
+
+// isNamespaceIncluded filters objects by namespace options. Cluster-scoped objects have no namespace
+// and are not filtered
+func isNamespaceIncluded(cfg *CommandArgs, res ResourceAndGroup) bool {
 	if !res.group.Namespaced {
-		namespace = "_cluster"
+		return true
+	}
+
+	return k8s.IsIncluded(res.resource.GetNamespace(), cfg.OnlyNamespaces, cfg.ExcludeNamespaces)
+}
+
+// errTransformFailed marks objects which transform rules cannot be applied to
+var errTransformFailed = errors.New("cannot transform object")
+
+// skipFailedTransform records object failed to transform in the summary, so the dump continues without it.
+// Other errors and --fail-fast abort the dump
+func skipFailedTransform(cfg *CommandArgs, summary *Summary, res ResourceAndGroup, err error) error {
+	if cfg.FailFast || !errors.Is(err, errTransformFailed) {
+		return err
+	}
+
+	slog.Error("Skipping object", "resource", res.group.String(), "namespace", res.resource.GetNamespace(), "name", res.resource.GetName(), "error", err)
+	summary.Fail(res.group.String(), err)
+
+	return nil
+}
+
+func writeObject(cfg *CommandArgs, res ResourceAndGroup, rules []*TransformRule, processors []postProcessor) error {
+	err := transformObject(rules, &res)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errTransformFailed, err)
+	}
+
+	fileName := getResourceFilePath(cfg, res)
+	fileData, err := serializeObject(cfg, res)
+	if err != nil {
+		return err
+	}
+
+	err = writeFile(cfg, fileName, fileData)
+	if err != nil {
+		return err
+	}
+
+	for _, processor := range processors {
+		err = processor.Process(res, fileName)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeFile writes file relative to output directory
+func writeFile(cfg *CommandArgs, fileName string, data []byte) error {
+	if cfg.DryRun {
+		slog.Info("Dry run", "file", fileName, "bytes", len(data))
+		return nil
+	}
+
+	err := cfg.output.WriteFile(fileName, data)
+	if err != nil {
+		return err
+	}
+
+	metrics.BytesWritten.WithLabelValues("manifests").Add(float64(len(data)))
+
+	return nil
+}
+
+func getResourceFilePath(cfg *CommandArgs, res ResourceAndGroup) string {
+	filePath := cfg.FileTemplate
+
+	// keep extra versions of the same object apart from the preferred one
+	resource := res.group.Resource
+	if res.group.ExtraVersion && !strings.Contains(filePath, "{version}") {
+		resource = resource + "_" + res.group.Version
 	}
 
 	filePath = strings.NewReplacer(
-		"{namespace}", removeIllegalFileChars(namespace),
+		"{namespace}", getNamespaceDirName(res),
 		"{kind}", removeIllegalFileChars(res.resource.GetKind()),
-		"{resource}", removeIllegalFileChars(res.group.Resource),
+		"{resource}", removeIllegalFileChars(resource),
+		"{version}", removeIllegalFileChars(res.group.Version),
 		"{name}", removeIllegalFileChars(res.resource.GetName()),
 	).Replace(filePath)
 
 	return filePath
 }
 
+// getNamespaceDirName returns {namespace} pattern value. Non-namespaced objects are placed in _cluster
+func getNamespaceDirName(res ResourceAndGroup) string {
+	if !res.group.Namespaced {
+		return "_cluster"
+	}
+
+	return removeIllegalFileChars(res.resource.GetNamespace())
+}
+
 func removeIllegalFileChars(fileName string) string {
 	return regexp.MustCompile("[^A-Za-z0-9._-]").ReplaceAllString(fileName, "-")
 }
 
-func serializeObject(cfg *CommandArgs, res ResourceAndGroup) ([]byte, error) {
+// cleanObject removes server populated fields, so object can be applied to another cluster
+func cleanObject(obj *unstructured.Unstructured) {
+	for _, field := range [][]string{
+		{"metadata", "uid"},
+		{"metadata", "resourceVersion"},
+		{"metadata", "generation"},
+		{"metadata", "creationTimestamp"},
+		{"metadata", "selfLink"},
+		{"metadata", "generateName"},
+		// owners have different uids in another cluster, garbage collector would remove the object
+		{"metadata", "ownerReferences"},
+		{"metadata", "annotations", "kubectl.kubernetes.io/last-applied-configuration"},
+		{"status"},
+	} {
+		unstructured.RemoveNestedField(obj.Object, field...)
+	}
+
+	// allocated service ips may be taken in another cluster. Headless services keep "None"
+	if obj.GetAPIVersion() == "v1" && obj.GetKind() == "Service" {
+		clusterIP, _, _ := unstructured.NestedString(obj.Object, "spec", "clusterIP")
+		if clusterIP != v1.ClusterIPNone {
+			unstructured.RemoveNestedField(obj.Object, "spec", "clusterIP")
+			unstructured.RemoveNestedField(obj.Object, "spec", "clusterIPs")
+		}
+	}
 
-	obj := res.resource
+	if len(obj.GetAnnotations()) == 0 {
+		unstructured.RemoveNestedField(obj.Object, "metadata", "annotations")
+	}
+}
+
+func serializeObject(cfg *CommandArgs, res ResourceAndGroup) ([]byte, error) {
+	// object is shared with the index and post processors, which need it unchanged
+	obj := res.resource.DeepCopy()
 
 	obj.SetManagedFields(nil)
 
+	if cfg.Clean {
+		cleanObject(obj)
+	}
+
 	payload, err := yaml.Marshal(obj.Object)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,246 @@
+package manifests
+
+import (
+	"context"
+	"errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/discovery"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+var listVerbs = metav1.Verbs{"get", "list", "watch"}
+
+func useFakeDiscovery(t *testing.T) {
+	t.Helper()
+
+	fake := &fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{
+		Resources: []*metav1.APIResourceList{
+			{
+				GroupVersion: "v1",
+				APIResources: []metav1.APIResource{
+					{Name: "pods", Kind: "Pod", Namespaced: true, Verbs: listVerbs},
+					{Name: "pods/log", Kind: "Pod", Namespaced: true, Verbs: metav1.Verbs{"get"}},
+					{Name: "configmaps", Kind: "ConfigMap", Namespaced: true, Verbs: listVerbs},
+					{Name: "nodes", Kind: "Node", Namespaced: false, Verbs: listVerbs},
+					{Name: "namespaces", Kind: "Namespace", Namespaced: false, Verbs: listVerbs},
+				},
+			},
+			{
+				GroupVersion: "rbac.authorization.k8s.io/v1",
+				APIResources: []metav1.APIResource{
+					{Name: "roles", Kind: "Role", Namespaced: true, Verbs: listVerbs},
+					{Name: "clusterroles", Kind: "ClusterRole", Namespaced: false, Verbs: listVerbs},
+				},
+			},
+		},
+	}}
+
+	previous := discoveryClient
+	discoveryClient = func(string, time.Duration) (discovery.DiscoveryInterface, error) {
+		return fake, nil
+	}
+	t.Cleanup(func() {
+		discoveryClient = previous
+	})
+}
+
+func listGroupNames(t *testing.T, cfg *CommandArgs) []string {
+	t.Helper()
+
+	groups, err := ListGroups(context.Background(), cfg, NewSummary())
+	if err != nil {
+		t.Fatalf("ListGroups: %v", err)
+	}
+
+	names := []string{}
+	for _, group := range groups {
+		names = append(names, group.String())
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func TestListGroupsScope(t *testing.T) {
+	useFakeDiscovery(t)
+
+	tests := []struct {
+		name string
+		cfg  CommandArgs
+		want []string
+	}{
+		{
+			name: "all",
+			cfg:  CommandArgs{},
+			want: []string{"clusterroles.rbac.authorization.k8s.io", "configmaps", "namespaces", "nodes", "pods", "roles.rbac.authorization.k8s.io"},
+		},
+		{
+			name: "no non-namespaced",
+			cfg:  CommandArgs{NoNonNamespaced: true},
+			want: []string{"configmaps", "pods", "roles.rbac.authorization.k8s.io"},
+		},
+		{
+			name: "only cluster-scoped",
+			cfg:  CommandArgs{OnlyClusterScoped: true},
+			want: []string{"clusterroles.rbac.authorization.k8s.io", "namespaces", "nodes"},
+		},
+		{
+			name: "only cluster-scoped with resource filter",
+			cfg:  CommandArgs{OnlyClusterScoped: true, OnlyResources: []string{"nodes", "pods"}},
+			want: []string{"nodes"},
+		},
+		{
+			name: "both scope flags",
+			cfg:  CommandArgs{NoNonNamespaced: true, OnlyClusterScoped: true},
+			want: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := listGroupNames(t, &tt.cfg)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsResourceIncludedScope(t *testing.T) {
+	namespaced := ResourceGroup{Version: "v1", Resource: "pods", Namespaced: true}
+	cluster := ResourceGroup{Version: "v1", Resource: "nodes"}
+
+	tests := []struct {
+		name          string
+		cfg           CommandArgs
+		wantNamespace bool
+		wantCluster   bool
+	}{
+		{"default", CommandArgs{}, true, true},
+		{"no non-namespaced", CommandArgs{NoNonNamespaced: true}, true, false},
+		{"only cluster-scoped", CommandArgs{OnlyClusterScoped: true}, false, true},
+		{"both scope flags", CommandArgs{NoNonNamespaced: true, OnlyClusterScoped: true}, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isResourceIncluded(&tt.cfg, namespaced); got != tt.wantNamespace {
+				t.Errorf("namespaced resource included = %v, want %v", got, tt.wantNamespace)
+			}
+
+			if got := isResourceIncluded(&tt.cfg, cluster); got != tt.wantCluster {
+				t.Errorf("cluster-scoped resource included = %v, want %v", got, tt.wantCluster)
+			}
+		})
+	}
+}
+
+func TestDumpRejectsBothScopeFlags(t *testing.T) {
+	cfg := &CommandArgs{Layout: LayoutDefault, NoNonNamespaced: true, OnlyClusterScoped: true}
+
+	err := Dump(cfg)
+	if !errors.Is(err, errConflictingScope) {
+		t.Fatalf("got error %v, want %v", err, errConflictingScope)
+	}
+}
+
+func TestCleanObject(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Service",
+		"metadata": map[string]interface{}{
+			"name":            "web",
+			"generateName":    "web-",
+			"uid":             "1234",
+			"resourceVersion": "10",
+			"ownerReferences": []interface{}{
+				map[string]interface{}{"apiVersion": "v1", "kind": "ConfigMap", "name": "owner", "uid": "5678"},
+			},
+		},
+		"spec": map[string]interface{}{
+			"clusterIP":  "10.0.0.1",
+			"clusterIPs": []interface{}{"10.0.0.1"},
+			"ports":      []interface{}{map[string]interface{}{"port": int64(80)}},
+		},
+		"status": map[string]interface{}{},
+	}}
+
+	cleanObject(obj)
+
+	want := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Service",
+		"metadata": map[string]interface{}{
+			"name": "web",
+		},
+		"spec": map[string]interface{}{
+			"ports": []interface{}{map[string]interface{}{"port": int64(80)}},
+		},
+	}
+
+	if !reflect.DeepEqual(obj.Object, want) {
+		t.Errorf("got %v, want %v", obj.Object, want)
+	}
+}
+
+func TestCleanObjectKeepsHeadlessService(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Service",
+		"metadata":   map[string]interface{}{"name": "db"},
+		"spec":       map[string]interface{}{"clusterIP": "None"},
+	}}
+
+	cleanObject(obj)
+
+	if obj.Object["spec"].(map[string]interface{})["clusterIP"] != "None" {
+		t.Errorf("clusterIP of headless service removed")
+	}
+}
+
+func TestNamespaceFilterKeepsClusterScoped(t *testing.T) {
+	useFakeDiscovery(t)
+
+	cfg := &CommandArgs{OnlyClusterScoped: true, OnlyNamespaces: []string{"foo"}}
+
+	groups, err := ListGroups(context.Background(), cfg, NewSummary())
+	if err != nil {
+		t.Fatalf("ListGroups: %v", err)
+	}
+
+	if len(groups) == 0 {
+		t.Fatal("no cluster-scoped resources listed")
+	}
+
+	for _, group := range groups {
+		obj := unstructured.Unstructured{}
+		obj.SetName("object")
+
+		if !isNamespaceIncluded(cfg, ResourceAndGroup{group: group, resource: obj}) {
+			t.Errorf("%s object dropped by --namespaces", group)
+		}
+	}
+
+	for _, tt := range []struct {
+		namespace string
+		want      bool
+	}{
+		{"foo", true},
+		{"bar", false},
+	} {
+		obj := unstructured.Unstructured{}
+		obj.SetNamespace(tt.namespace)
+		obj.SetName("object")
+
+		got := isNamespaceIncluded(cfg, ResourceAndGroup{group: ResourceGroup{Version: "v1", Resource: "pods", Namespaced: true}, resource: obj})
+		if got != tt.want {
+			t.Errorf("pod in %s included = %v, want %v", tt.namespace, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package manifests
+
+import (
+	"encoding/base64"
+	"fmt"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"log/slog"
+	"path"
+	"strings"
+)
+
+type dataExtractor struct {
+	cfg *CommandArgs
+}
+
+func (e *dataExtractor) Process(res ResourceAndGroup, fileName string) error {
+	return extractData(e.cfg, res, fileName)
+}
+
+func (e *dataExtractor) Finish() error {
+	return nil
+}
+
+// extractData writes every key of configmap or secret into a directory named after the manifest file.
+// Secret values and configmap binaryData are decoded, so files can be used with --from-file
+func extractData(cfg *CommandArgs, res ResourceAndGroup, fileName string) error {
+	if res.group.Group != "" {
+		return nil
+	}
+
+	var fields map[string]bool
+
+	switch res.group.Resource {
+	case "configmaps":
+		fields = map[string]bool{"data": false, "binaryData": true}
+	case "secrets":
+		fields = map[string]bool{"data": true}
+	default:
+		return nil
+	}
+
+	dir := strings.TrimSuffix(fileName, path.Ext(fileName))
+	if dir == fileName {
+		dir = dir + "_data"
+	}
+
+	for field, encoded := range fields {
+		data, _, err := unstructured.NestedStringMap(res.resource.Object, field)
+		if err != nil {
+			return err
+		}
+
+		for key, value := range data {
+			// keys are validated by api server, but never let them escape the directory
+			if key == "." || key == ".." || strings.ContainsAny(key, "/\\") {
+				slog.Warn("Skipping unsafe key", "key", key, "resource", res.group.String(), "namespace", res.resource.GetNamespace(), "name", res.resource.GetName())
+				continue
+			}
+
+			payload := []byte(value)
+			if encoded {
+				payload, err = base64.StdEncoding.DecodeString(value)
+				if err != nil {
+					return fmt.Errorf("cannot decode key %q of %s %s/%s: %w", key, res.group.Resource, res.resource.GetNamespace(), res.resource.GetName(), err)
+				}
+			}
+
+			err = writeFile(cfg, path.Join(dir, key), payload)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
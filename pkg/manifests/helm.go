@@ -0,0 +1,274 @@
+package manifests
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"log/slog"
+	"path"
+	"sort"
+	"strings"
+)
+
+const helmReleaseSecretType = "helm.sh/release.v1"
+
+var gzipMagic = []byte{0x1f, 0x8b, 0x08}
+
+// helmRelease mirrors json representation of helm 3 release stored by its storage drivers
+type helmRelease struct {
+	Name      string                 `json:"name"`
+	Namespace string                 `json:"namespace"`
+	Version   int                    `json:"version"`
+	Info      *helmInfo              `json:"info"`
+	Chart     *helmChart             `json:"chart"`
+	Config    map[string]interface{} `json:"config"`
+	Manifest  string                 `json:"manifest"`
+	Hooks     []*helmHook            `json:"hooks"`
+}
+
+type helmInfo struct {
+	FirstDeployed string `json:"first_deployed"`
+	LastDeployed  string `json:"last_deployed"`
+	Description   string `json:"description"`
+	Status        string `json:"status"`
+	Notes         string `json:"notes"`
+}
+
+type helmChart struct {
+	Metadata  map[string]interface{} `json:"metadata"`
+	Templates []*helmFile            `json:"templates"`
+	Values    map[string]interface{} `json:"values"`
+	Schema    []byte                 `json:"schema"`
+	Files     []*helmFile            `json:"files"`
+}
+
+type helmFile struct {
+	Name string `json:"name"`
+	Data []byte `json:"data"`
+}
+
+type helmHook struct {
+	Name     string `json:"name"`
+	Kind     string `json:"kind"`
+	Manifest string `json:"manifest"`
+}
+
+type helmHistoryEntry struct {
+	Revision    int    `yaml:"revision"`
+	Updated     string `yaml:"updated,omitempty"`
+	Status      string `yaml:"status"`
+	Chart       string `yaml:"chart"`
+	AppVersion  string `yaml:"appVersion,omitempty"`
+	Description string `yaml:"description,omitempty"`
+}
+
+// helmExporter decodes helm release secrets and configmaps into reinstallable charts with values
+type helmExporter struct {
+	cfg     *CommandArgs
+	history map[string]map[int]helmHistoryEntry
+}
+
+func newHelmExporter(cfg *CommandArgs) *helmExporter {
+	return &helmExporter{
+		cfg:     cfg,
+		history: make(map[string]map[int]helmHistoryEntry),
+	}
+}
+
+func (h *helmExporter) Process(res ResourceAndGroup, fileName string) error {
+	if res.group.Group != "" {
+		return nil
+	}
+
+	obj := res.resource.Object
+
+	var encoded string
+
+	switch res.group.Resource {
+	case "secrets":
+		secretType, _, _ := unstructured.NestedString(obj, "type")
+		if secretType != helmReleaseSecretType {
+			return nil
+		}
+
+		data, _, _ := unstructured.NestedString(obj, "data", "release")
+		decoded, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			slog.Warn("Cannot decode helm release secret", "namespace", res.resource.GetNamespace(), "name", res.resource.GetName(), "error", err)
+			return nil
+		}
+		encoded = string(decoded)
+
+	case "configmaps":
+		if res.resource.GetLabels()["owner"] != "helm" {
+			return nil
+		}
+
+		encoded, _, _ = unstructured.NestedString(obj, "data", "release")
+
+	default:
+		return nil
+	}
+
+	release, err := decodeHelmRelease(encoded)
+	if err != nil {
+		slog.Warn("Cannot decode helm release", "namespace", res.resource.GetNamespace(), "name", res.resource.GetName(), "error", err)
+		return nil
+	}
+
+	err = h.writeRelease(release)
+	if err != nil {
+		return err
+	}
+
+	entry := helmHistoryEntry{Revision: release.Version}
+	if release.Info != nil {
+		entry.Updated = release.Info.LastDeployed
+		entry.Status = release.Info.Status
+		entry.Description = release.Info.Description
+	}
+	if release.Chart != nil {
+		entry.Chart = fmt.Sprintf("%v-%v", release.Chart.Metadata["name"], release.Chart.Metadata["version"])
+		if appVersion, ok := release.Chart.Metadata["appVersion"]; ok {
+			entry.AppVersion = fmt.Sprint(appVersion)
+		}
+	}
+
+	dir := h.releaseDir(release)
+	if h.history[dir] == nil {
+		h.history[dir] = make(map[int]helmHistoryEntry)
+	}
+	h.history[dir][release.Version] = entry
+
+	return nil
+}
+
+// Finish writes revision history of every release
+func (h *helmExporter) Finish() error {
+	for dir, revisions := range h.history {
+		var entries []helmHistoryEntry
+		for _, entry := range revisions {
+			entries = append(entries, entry)
+		}
+
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].Revision < entries[j].Revision
+		})
+
+		data, err := yaml.Marshal(entries)
+		if err != nil {
+			return err
+		}
+
+		err = writeFile(h.cfg, path.Join(dir, "history.yaml"), data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (h *helmExporter) releaseDir(release *helmRelease) string {
+	return path.Join("helm", removeIllegalFileChars(release.Namespace), removeIllegalFileChars(release.Name))
+}
+
+// writeRelease writes chart, user supplied values and rendered manifests of the revision.
+// Release can be reinstalled with: helm install <name> ./chart -f values.yaml
+func (h *helmExporter) writeRelease(release *helmRelease) error {
+	dir := path.Join(h.releaseDir(release), fmt.Sprintf("v%d", release.Version))
+
+	files := make(map[string][]byte)
+
+	values, err := yaml.Marshal(release.Config)
+	if err != nil {
+		return err
+	}
+	files["values.yaml"] = values
+	files["manifest.yaml"] = []byte(release.Manifest)
+
+	if len(release.Hooks) > 0 {
+		var hooks []string
+		for _, hook := range release.Hooks {
+			hooks = append(hooks, hook.Manifest)
+		}
+		files["hooks.yaml"] = []byte(strings.Join(hooks, "\n---\n"))
+	}
+
+	if release.Info != nil && release.Info.Notes != "" {
+		files["NOTES.txt"] = []byte(release.Info.Notes)
+	}
+
+	if release.Chart != nil {
+		metadata, err := yaml.Marshal(release.Chart.Metadata)
+		if err != nil {
+			return err
+		}
+		files["chart/Chart.yaml"] = metadata
+
+		chartValues, err := yaml.Marshal(release.Chart.Values)
+		if err != nil {
+			return err
+		}
+		files["chart/values.yaml"] = chartValues
+
+		if len(release.Chart.Schema) > 0 {
+			files["chart/values.schema.json"] = release.Chart.Schema
+		}
+
+		for _, file := range append(release.Chart.Templates, release.Chart.Files...) {
+			name := path.Clean("/" + file.Name)[1:]
+			if name == "" {
+				continue
+			}
+			files[path.Join("chart", name)] = file.Data
+		}
+	}
+
+	for name, data := range files {
+		err = writeFile(h.cfg, path.Join(dir, name), data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decodeHelmRelease decodes base64 encoded, optionally gzipped, json release
+func decodeHelmRelease(data string) (*helmRelease, error) {
+	payload, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(payload, gzipMagic) {
+		reader, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+
+		payload, err = io.ReadAll(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		err = reader.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var release helmRelease
+	err = json.Unmarshal(payload, &release)
+	if err != nil {
+		return nil, err
+	}
+
+	return &release, nil
+}
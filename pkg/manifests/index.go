@@ -0,0 +1,218 @@
+package manifests
+
+import (
+	"context"
+	"golang.org/x/sync/errgroup"
+	"gopkg.in/yaml.v2"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+const indexFileName = "index.yaml"
+
+// ListRecord describes a single list request made during the dump
+type ListRecord struct {
+	Resource        string `yaml:"resource"`
+	Version         string `yaml:"version"`
+	Namespace       string `yaml:"namespace,omitempty"`
+	ResourceVersion string `yaml:"resourceVersion"`
+	ListedAt        string `yaml:"listedAt"`
+	Count           int    `yaml:"count"`
+}
+
+// ObjectChange describes an object modified or deleted while the dump was running
+type ObjectChange struct {
+	Resource           string `yaml:"resource"`
+	Namespace          string `yaml:"namespace,omitempty"`
+	Name               string `yaml:"name"`
+	ResourceVersion    string `yaml:"resourceVersion"`
+	NewResourceVersion string `yaml:"newResourceVersion,omitempty"`
+}
+
+type objectRecord struct {
+	resource        string
+	namespace       string
+	name            string
+	resourceVersion string
+}
+
+// Index is written to the root of the dump and records when and at which resourceVersion
+// every resource was listed. VerifyLists are lists of the consistency check
+type Index struct {
+	StartedAt         string         `yaml:"startedAt"`
+	FinishedAt        string         `yaml:"finishedAt"`
+	ConsistencyWindow string         `yaml:"consistencyWindow"`
+	Verified          bool           `yaml:"verified"`
+	Lists             []ListRecord   `yaml:"lists"`
+	VerifyLists       []ListRecord   `yaml:"verifyLists,omitempty"`
+	Changed           []ObjectChange `yaml:"changed,omitempty"`
+	Deleted           []ObjectChange `yaml:"deleted,omitempty"`
+
+	mu      sync.Mutex
+	objects map[string]objectRecord
+}
+
+func NewIndex() *Index {
+	return &Index{
+		StartedAt: time.Now().UTC().Format(time.RFC3339Nano),
+		objects:   make(map[string]objectRecord),
+	}
+}
+
+func getObjectKey(res ResourceAndGroup) string {
+	return res.group.String() + "/" + res.resource.GetNamespace() + "/" + res.resource.GetName()
+}
+
+func (i *Index) AddLists(records []ListRecord) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.Lists = append(i.Lists, records...)
+}
+
+// AddObject remembers resourceVersion of the written object
+func (i *Index) AddObject(res ResourceAndGroup) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.objects[getObjectKey(res)] = objectRecord{
+		resource:        res.group.String(),
+		namespace:       res.resource.GetNamespace(),
+		name:            res.resource.GetName(),
+		resourceVersion: res.resource.GetResourceVersion(),
+	}
+}
+
+// Finish sets the consistency window: time between the first and the last list of the data written
+// to the dump, including lists of the consistency check
+func (i *Index) Finish() {
+	i.FinishedAt = time.Now().UTC().Format(time.RFC3339Nano)
+
+	var first, last time.Time
+
+	for _, record := range append(append([]ListRecord(nil), i.Lists...), i.VerifyLists...) {
+		listedAt, err := time.Parse(time.RFC3339Nano, record.ListedAt)
+		if err != nil {
+			continue
+		}
+
+		if first.IsZero() || listedAt.Before(first) {
+			first = listedAt
+		}
+		if listedAt.After(last) {
+			last = listedAt
+		}
+	}
+
+	i.ConsistencyWindow = last.Sub(first).Round(time.Millisecond).String()
+}
+
+func (i *Index) Write(cfg *CommandArgs) error {
+	data, err := yaml.Marshal(i)
+	if err != nil {
+		return err
+	}
+
+	return writeFile(cfg, indexFileName, data)
+}
+
+// verifyConsistency lists every resource once more and re-writes objects changed since the first pass.
+// Objects which disappeared are recorded as deleted but kept in the dump
+func verifyConsistency(ctx context.Context, cfg *CommandArgs, groups []ResourceGroup, index *Index, summary *Summary, rules []*TransformRule, processors []postProcessor) error {
+	var (
+		failed = make(map[string]bool)
+		seen   = make(map[string]bool)
+	)
+
+	g, gctx := errgroup.WithContext(ctx)
+
+	slog.Info("Verifying consistency", "objects", len(index.objects))
+
+	resourceChannel := make(chan ResourceAndGroup, 15)
+
+	g.Go(func() error {
+		defer close(resourceChannel)
+
+		for _, group := range groups {
+			records, err := DiscoverResources(gctx, group, cfg.OnlyNamespaces, resourceChannel)
+			index.VerifyLists = append(index.VerifyLists, records...)
+			if err != nil {
+				if gctx.Err() != nil {
+					return err
+				}
+
+				slog.Warn("Cannot verify resource", "resource", group.String(), "error", err)
+				failed[group.String()] = true
+			}
+		}
+
+		return nil
+	})
+
+	g.Go(func() error {
+		for res := range resourceChannel {
+			key := getObjectKey(res)
+
+			record, ok := index.objects[key]
+			if !ok {
+				// filtered out or created after the first pass
+				continue
+			}
+
+			seen[key] = true
+
+			if record.resourceVersion == res.resource.GetResourceVersion() {
+				continue
+			}
+
+			index.Changed = append(index.Changed, ObjectChange{
+				Resource:           record.resource,
+				Namespace:          record.namespace,
+				Name:               record.name,
+				ResourceVersion:    record.resourceVersion,
+				NewResourceVersion: res.resource.GetResourceVersion(),
+			})
+
+			err := writeObject(cfg, res, rules, processors)
+			if err != nil {
+				err = skipFailedTransform(cfg, summary, res, err)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+
+	err := g.Wait()
+	if err != nil {
+		return err
+	}
+
+	for key, record := range index.objects {
+		if seen[key] || failed[record.resource] {
+			continue
+		}
+
+		index.Deleted = append(index.Deleted, ObjectChange{
+			Resource:        record.resource,
+			Namespace:       record.namespace,
+			Name:            record.name,
+			ResourceVersion: record.resourceVersion,
+		})
+	}
+
+	sort.Slice(index.Deleted, func(i, j int) bool {
+		a, b := index.Deleted[i], index.Deleted[j]
+		return a.Resource+"/"+a.Namespace+"/"+a.Name < b.Resource+"/"+b.Namespace+"/"+b.Name
+	})
+
+	index.Verified = true
+
+	slog.Info("Consistency verified", "changed", len(index.Changed), "deleted", len(index.Deleted))
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package manifests
+
+import (
+	"context"
+	"github.com/ThunderAl197/kubedump/pkg/k8s"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakedynamic "k8s.io/client-go/dynamic/fake"
+	"testing"
+)
+
+var configMaps = ResourceGroup{Version: "v1", Resource: "configmaps", Namespaced: true}
+
+func newConfigMap(name, resourceVersion string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("v1")
+	obj.SetKind("ConfigMap")
+	obj.SetNamespace("default")
+	obj.SetName(name)
+	obj.SetResourceVersion(resourceVersion)
+
+	return obj
+}
+
+func useFakeDynamicClient(t *testing.T, objects ...runtime.Object) {
+	t.Helper()
+
+	client := fakedynamic.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{{Version: "v1", Resource: "configmaps"}: "ConfigMapList"},
+		objects...,
+	)
+
+	previous := k8s.KDynClient
+	k8s.KDynClient = client
+	t.Cleanup(func() {
+		k8s.KDynClient = previous
+	})
+}
+
+func TestVerifyConsistencyWithClean(t *testing.T) {
+	useFakeDynamicClient(t, newConfigMap("same", "10"), newConfigMap("changed", "12"))
+
+	cfg := &CommandArgs{DryRun: true, Clean: true, VerifyConsistency: true}
+	index := NewIndex()
+
+	for _, obj := range []*unstructured.Unstructured{newConfigMap("same", "10"), newConfigMap("changed", "11")} {
+		res := ResourceAndGroup{group: configMaps, resource: *obj}
+
+		err := writeObject(cfg, res, nil, nil)
+		if err != nil {
+			t.Fatalf("writeObject: %v", err)
+		}
+
+		index.AddObject(res)
+
+		if res.resource.GetResourceVersion() == "" {
+			t.Fatalf("writeObject removed resourceVersion of %s", obj.GetName())
+		}
+	}
+
+	err := verifyConsistency(context.Background(), cfg, []ResourceGroup{configMaps}, index, NewSummary(), nil, nil)
+	if err != nil {
+		t.Fatalf("verifyConsistency: %v", err)
+	}
+
+	if len(index.Changed) != 1 {
+		t.Fatalf("got %d changed objects, want 1: %+v", len(index.Changed), index.Changed)
+	}
+
+	change := index.Changed[0]
+	if change.Name != "changed" || change.ResourceVersion != "11" || change.NewResourceVersion != "12" {
+		t.Errorf("unexpected change %+v", change)
+	}
+
+	if len(index.Deleted) != 0 {
+		t.Errorf("got deleted objects %+v, want none", index.Deleted)
+	}
+}
+
+func TestConsistencyWindow(t *testing.T) {
+	index := NewIndex()
+	index.AddLists([]ListRecord{
+		{Resource: "pods", ListedAt: "2024-01-01T10:00:02Z"},
+		{Resource: "configmaps", ListedAt: "2024-01-01T10:00:00Z"},
+	})
+	index.VerifyLists = []ListRecord{{Resource: "pods", ListedAt: "2024-01-01T10:00:05Z"}}
+
+	index.Finish()
+
+	if index.ConsistencyWindow != "5s" {
+		t.Errorf("got window %s, want 5s", index.ConsistencyWindow)
+	}
+}
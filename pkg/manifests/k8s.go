@@ -6,14 +6,36 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
-	"log"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"log/slog"
+	"strings"
+	"time"
 )
 
+// discoveryClient is a variable, so tests can serve resources from a fake client
+var discoveryClient = k8s.DiscoveryClient
+
 type ResourceGroup struct {
 	Group      string
 	Version    string
 	Resource   string
 	Namespaced bool
+	// ExtraVersion is set for non-preferred versions dumped in addition to the preferred one
+	ExtraVersion bool
+}
+
+func (r ResourceGroup) String() string {
+	name := r.Resource
+	if r.Group != "" {
+		name = name + "." + r.Group
+	}
+
+	if r.ExtraVersion {
+		name = name + "/" + r.Version
+	}
+
+	return name
 }
 
 type ResourceAndGroup struct {
@@ -21,49 +43,113 @@ type ResourceAndGroup struct {
 	resource unstructured.Unstructured
 }
 
-func DiscoverResources(ctx context.Context, res ResourceGroup, ch chan<- ResourceAndGroup) error {
-	var (
-		list *unstructured.UnstructuredList
-		err  error
-	)
-
-	if res.Namespaced {
-		list, err = k8s.KDynClient.
-			Resource(schema.GroupVersionResource{Group: res.Group, Version: res.Version, Resource: res.Resource}).
-			Namespace("").
-			List(ctx, metav1.ListOptions{})
-	} else {
-		list, err = k8s.KDynClient.
-			Resource(schema.GroupVersionResource{Group: res.Group, Version: res.Version, Resource: res.Resource}).
-			List(ctx, metav1.ListOptions{})
+// DiscoverResources lists all objects of the resource. Namespaced resources are listed
+// per namespace when namespaces are given, otherwise across all namespaces
+func DiscoverResources(ctx context.Context, res ResourceGroup, namespaces []string, ch chan<- ResourceAndGroup) ([]ListRecord, error) {
+	gvr := schema.GroupVersionResource{Group: res.Group, Version: res.Version, Resource: res.Resource}
+
+	if !res.Namespaced || len(namespaces) == 0 {
+		namespaces = []string{""}
 	}
 
+	var records []ListRecord
+
+	for _, ns := range namespaces {
+		client := dynamic.ResourceInterface(k8s.KDynClient.Resource(gvr))
+		if res.Namespaced {
+			client = k8s.KDynClient.Resource(gvr).Namespace(ns)
+		}
+
+		record, err := listResources(ctx, res, ns, client, ch)
+		if err != nil {
+			return records, err
+		}
+
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+func listResources(ctx context.Context, res ResourceGroup, namespace string, client dynamic.ResourceInterface, ch chan<- ResourceAndGroup) (ListRecord, error) {
+	listedAt := time.Now()
+
+	list, err := client.List(ctx, metav1.ListOptions{})
 	if err != nil {
-		return err
+		return ListRecord{}, err
 	}
 
 	for _, obj := range list.Items {
-		ch <- ResourceAndGroup{res, obj}
+		select {
+		case ch <- ResourceAndGroup{res, obj}:
+		case <-ctx.Done():
+			return ListRecord{}, ctx.Err()
+		}
 	}
 
-	return nil
+	return ListRecord{
+		Resource:        res.String(),
+		Version:         res.Version,
+		Namespace:       namespace,
+		ResourceVersion: list.GetResourceVersion(),
+		ListedAt:        listedAt.UTC().Format(time.RFC3339Nano),
+		Count:           len(list.Items),
+	}, nil
 }
 
-func DiscoverGroups(ctx context.Context, ch chan<- ResourceGroup) error {
-	discovery := k8s.KClient.Discovery()
-	groupList, err := discovery.ServerGroups()
+// DiscoverGroups sends preferred version of every listable resource to the channel.
+// Groups which failed to discover are skipped and recorded in the summary
+func DiscoverGroups(ctx context.Context, cfg *CommandArgs, summary *Summary, ch chan<- ResourceGroup) error {
+	client, err := discoveryClient(cfg.DiscoveryCacheDir, cfg.DiscoveryCacheTTL)
 	if err != nil {
 		return err
 	}
 
-	for _, group := range groupList.Groups {
-		resourceList, err := discovery.ServerResourcesForGroupVersion(group.PreferredVersion.GroupVersion)
+	resourceLists, err := discovery.ServerPreferredResources(client)
+	if err != nil {
+		failed, ok := err.(*discovery.ErrGroupDiscoveryFailed)
+		if !ok || cfg.FailFast {
+			return err
+		}
+
+		for gv, groupErr := range failed.Groups {
+			slog.Error("Cannot discover group", "group", gv.String(), "error", groupErr)
+			summary.Fail(gv.String(), groupErr)
+		}
+	}
+
+	var crds map[schema.GroupResource]crdVersions
+	if cfg.CrdVersions != "" && cfg.CrdVersions != CrdVersionsPreferred {
+		crds, err = discoverCrdVersions(ctx)
+		if err != nil {
+			if cfg.FailFast {
+				return err
+			}
+
+			slog.Warn("Cannot discover custom resource versions, using preferred ones", "error", err)
+		}
+	}
+
+	for _, resourceList := range resourceLists {
+		gv, err := schema.ParseGroupVersion(resourceList.GroupVersion)
 		if err != nil {
-			log.Printf("Cannot discover group %s\n", group.PreferredVersion.GroupVersion)
+			return err
+		}
+
+		groupName := gv.Group
+		if groupName == "" {
+			groupName = "core"
+		}
+
+		if !k8s.IsIncluded(groupName, cfg.OnlyApiGroups, cfg.ExcludeApiGroups) {
 			continue
 		}
 
 		for _, resource := range resourceList.APIResources {
+			// subresources like pods/log cannot be listed on their own
+			if strings.Contains(resource.Name, "/") {
+				continue
+			}
 
 			canList := false
 
@@ -77,12 +163,22 @@ func DiscoverGroups(ctx context.Context, ch chan<- ResourceGroup) error {
 				continue
 			}
 
-			ch <- ResourceGroup{
-				Group:      group.Name,
-				Version:    group.PreferredVersion.Version,
+			res := ResourceGroup{
+				Group:      gv.Group,
+				Version:    gv.Version,
 				Resource:   resource.Name,
 				Namespaced: resource.Namespaced,
 			}
+
+			info, ok := crds[schema.GroupResource{Group: gv.Group, Resource: resource.Name}]
+			if !ok {
+				ch <- res
+				continue
+			}
+
+			for _, crdRes := range crdResourceGroups(cfg.CrdVersions, res, info) {
+				ch <- crdRes
+			}
 		}
 	}
 
@@ -0,0 +1,34 @@
+package manifests
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDiscoverResourcesStopsWhenCancelled(t *testing.T) {
+	useFakeDynamicClient(t, newConfigMap("first", "1"), newConfigMap("second", "1"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// nobody reads the channel, like a writer which failed
+	ch := make(chan ResourceAndGroup)
+	done := make(chan error, 1)
+
+	go func() {
+		_, err := DiscoverResources(ctx, configMaps, nil, ch)
+		done <- err
+	}()
+
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("got error %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("DiscoverResources blocked on channel after cancel")
+	}
+}
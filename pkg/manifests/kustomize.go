@@ -0,0 +1,96 @@
+package manifests
+
+import (
+	"gopkg.in/yaml.v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	LayoutDefault   = "default"
+	LayoutKustomize = "kustomize"
+)
+
+const kustomizeFileTemplate = "base/{namespace}/{resource}/{name}.yaml"
+
+type kustomization struct {
+	ApiVersion string   `yaml:"apiVersion"`
+	Kind       string   `yaml:"kind"`
+	Resources  []string `yaml:"resources"`
+}
+
+func newKustomization(resources []string) kustomization {
+	sort.Strings(resources)
+
+	return kustomization{
+		ApiVersion: "kustomize.config.k8s.io/v1beta1",
+		Kind:       "Kustomization",
+		Resources:  resources,
+	}
+}
+
+// kustomizeLayout generates kustomization.yaml for every namespace directory in base/
+// and a top level one referencing all of them
+type kustomizeLayout struct {
+	cfg       *CommandArgs
+	resources map[string]map[string]bool
+}
+
+func newKustomizeLayout(cfg *CommandArgs) *kustomizeLayout {
+	return &kustomizeLayout{
+		cfg:       cfg,
+		resources: make(map[string]map[string]bool),
+	}
+}
+
+func (k *kustomizeLayout) Process(res ResourceAndGroup, fileName string) error {
+	// the same object can be listed by kustomize only once
+	if res.group.ExtraVersion {
+		return nil
+	}
+
+	// pods, replica sets and similar are recreated by their controllers
+	if metav1.GetControllerOfNoCopy(&res.resource) != nil {
+		return nil
+	}
+
+	dir := path.Join("base", getNamespaceDirName(res))
+	if k.resources[dir] == nil {
+		k.resources[dir] = make(map[string]bool)
+	}
+	k.resources[dir][strings.TrimPrefix(fileName, dir+"/")] = true
+
+	return nil
+}
+
+func (k *kustomizeLayout) Finish() error {
+	var dirs []string
+
+	for dir, files := range k.resources {
+		dirs = append(dirs, dir)
+
+		var resources []string
+		for file := range files {
+			resources = append(resources, file)
+		}
+
+		data, err := yaml.Marshal(newKustomization(resources))
+		if err != nil {
+			return err
+		}
+
+		err = writeFile(k.cfg, path.Join(dir, "kustomization.yaml"), data)
+		if err != nil {
+			return err
+		}
+	}
+
+	data, err := yaml.Marshal(newKustomization(dirs))
+	if err != nil {
+		return err
+	}
+
+	return writeFile(k.cfg, "kustomization.yaml", data)
+}
@@ -0,0 +1,47 @@
+package manifests
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"reflect"
+	"testing"
+)
+
+func TestKustomizeLayoutSkipsControlledObjects(t *testing.T) {
+	pods := ResourceGroup{Version: "v1", Resource: "pods", Namespaced: true}
+	controller := true
+
+	standalone := unstructured.Unstructured{}
+	standalone.SetNamespace("default")
+	standalone.SetName("standalone")
+
+	owned := unstructured.Unstructured{}
+	owned.SetNamespace("default")
+	owned.SetName("web-abc12")
+	owned.SetOwnerReferences([]metav1.OwnerReference{{
+		APIVersion: "apps/v1",
+		Kind:       "ReplicaSet",
+		Name:       "web",
+		UID:        "1234",
+		Controller: &controller,
+	}})
+
+	layout := newKustomizeLayout(&CommandArgs{FileTemplate: kustomizeFileTemplate})
+
+	for _, obj := range []unstructured.Unstructured{standalone, owned} {
+		res := ResourceAndGroup{group: pods, resource: obj}
+
+		err := layout.Process(res, getResourceFilePath(layout.cfg, res))
+		if err != nil {
+			t.Fatalf("Process: %v", err)
+		}
+	}
+
+	want := map[string]map[string]bool{
+		"base/default": {"pods/standalone.yaml": true},
+	}
+
+	if !reflect.DeepEqual(layout.resources, want) {
+		t.Errorf("got %v, want %v", layout.resources, want)
+	}
+}
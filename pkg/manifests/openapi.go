@@ -0,0 +1,38 @@
+package manifests
+
+import (
+	"github.com/ThunderAl197/kubedump/pkg/k8s"
+	"log/slog"
+	"path"
+)
+
+const openApiDir = "openapi/v3"
+
+// saveOpenApi writes OpenAPI v3 document of every group version, so dump can be validated offline
+func saveOpenApi(cfg *CommandArgs) error {
+	paths, err := k8s.KClient.Discovery().OpenAPIV3().Paths()
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Saving OpenAPI documents", "count", len(paths))
+
+	for name, gv := range paths {
+		data, err := gv.Schema("application/json")
+		if err != nil {
+			if cfg.FailFast {
+				return err
+			}
+
+			slog.Warn("Cannot load OpenAPI document", "path", name, "error", err)
+			continue
+		}
+
+		err = writeFile(cfg, path.Join(openApiDir, path.Clean("/" + name)[1:]+".json"), data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,125 @@
+package manifests
+
+import (
+	"context"
+	"fmt"
+	"github.com/ThunderAl197/kubedump/pkg/k8s"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+func resourceAccessChecks(cfg *CommandArgs, group ResourceGroup) []k8s.AccessCheck {
+	if !group.Namespaced || len(cfg.OnlyNamespaces) == 0 {
+		return []k8s.AccessCheck{
+			{Verb: "list", Group: group.Group, Resource: group.Resource},
+		}
+	}
+
+	var checks []k8s.AccessCheck
+	for _, ns := range cfg.OnlyNamespaces {
+		checks = append(checks, k8s.AccessCheck{
+			Verb:      "list",
+			Group:     group.Group,
+			Resource:  group.Resource,
+			Namespace: ns,
+		})
+	}
+
+	return checks
+}
+
+// preflightGroups drops resources which current user is not allowed to list. Checks of all resources
+// are sent at once, so they run concurrently
+func preflightGroups(ctx context.Context, cfg *CommandArgs, groups []ResourceGroup, summary *Summary) ([]ResourceGroup, error) {
+	var (
+		allowed []ResourceGroup
+		checks  []k8s.AccessCheck
+		owners  []int
+	)
+
+	for i, group := range groups {
+		for _, check := range resourceAccessChecks(cfg, group) {
+			checks = append(checks, check)
+			owners = append(owners, i)
+		}
+	}
+
+	results, err := k8s.CheckAccess(ctx, checks)
+	if err != nil {
+		return nil, err
+	}
+
+	denied := make(map[int][]string)
+	for i, result := range results {
+		if !result.Allowed {
+			denied[owners[i]] = append(denied[owners[i]], result.Check.String())
+		}
+	}
+
+	for i, group := range groups {
+		if len(denied[i]) > 0 {
+			err := fmt.Errorf("forbidden to %s", strings.Join(denied[i], ", "))
+			if cfg.FailFast {
+				return nil, err
+			}
+
+			slog.Warn("Skipping resource, permission denied", "resource", group.String(), "error", err)
+			summary.Fail(group.String(), err)
+			continue
+		}
+
+		allowed = append(allowed, group)
+	}
+
+	return allowed, nil
+}
+
+func Check(cfg *CommandArgs, printClusterRole bool) error {
+	var err error
+
+	ctx := context.Background()
+
+	err = k8s.InitClient(cfg.Kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	groups, err := ListGroups(ctx, cfg, NewSummary())
+	if err != nil {
+		return err
+	}
+
+	var checks []k8s.AccessCheck
+
+	if cfg.CrdVersions != CrdVersionsPreferred {
+		checks = append(checks, k8s.AccessCheck{
+			Verb:     "list",
+			Group:    crdResource.Group,
+			Resource: crdResource.Resource,
+		})
+	}
+
+	for _, group := range groups {
+		checks = append(checks, resourceAccessChecks(cfg, group)...)
+	}
+
+	// keep stdout clean for the role manifest
+	out := os.Stdout
+	if printClusterRole {
+		role, err := k8s.ClusterRoleYaml("kubedump-manifests", checks)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(string(role))
+		out = os.Stderr
+	}
+
+	results, err := k8s.CheckAccess(ctx, checks)
+	if err != nil {
+		return err
+	}
+
+	return k8s.ReportAccess(out, results)
+}
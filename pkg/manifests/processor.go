@@ -0,0 +1,26 @@
+package manifests
+
+// postProcessor receives every dumped object and may write additional files
+type postProcessor interface {
+	Process(res ResourceAndGroup, fileName string) error
+	// Finish is called once all objects are processed
+	Finish() error
+}
+
+func getPostProcessors(cfg *CommandArgs) []postProcessor {
+	var processors []postProcessor
+
+	if cfg.ExtractData {
+		processors = append(processors, &dataExtractor{cfg: cfg})
+	}
+
+	if cfg.HelmReleases {
+		processors = append(processors, newHelmExporter(cfg))
+	}
+
+	if cfg.Layout == LayoutKustomize {
+		processors = append(processors, newKustomizeLayout(cfg))
+	}
+
+	return processors
+}
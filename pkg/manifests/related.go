@@ -0,0 +1,132 @@
+package manifests
+
+import (
+	"github.com/ThunderAl197/kubedump/pkg/k8s"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// relatedObjects buffers cluster-scoped objects and selects ones related to dumped namespaces
+type relatedObjects struct {
+	cfg            *CommandArgs
+	pending        []ResourceAndGroup
+	volumes        map[string]bool
+	storageClasses map[string]bool
+	clusterRoles   map[string]bool
+	resources      map[string]bool
+}
+
+func newRelatedObjects(cfg *CommandArgs) *relatedObjects {
+	return &relatedObjects{
+		cfg:            cfg,
+		volumes:        make(map[string]bool),
+		storageClasses: make(map[string]bool),
+		clusterRoles:   make(map[string]bool),
+		resources:      make(map[string]bool),
+	}
+}
+
+func (r *relatedObjects) isNamespaceIncluded(ns string) bool {
+	return ns != "" && k8s.IsIncluded(ns, r.cfg.OnlyNamespaces, r.cfg.ExcludeNamespaces)
+}
+
+// Defer keeps cluster-scoped object until all namespaced objects are observed
+func (r *relatedObjects) Defer(res ResourceAndGroup) {
+	r.pending = append(r.pending, res)
+}
+
+// Observe records cluster-scoped objects referenced by dumped namespaced object
+func (r *relatedObjects) Observe(res ResourceAndGroup) {
+	obj := res.resource.Object
+
+	// custom resources are matched with their crd by name
+	if res.group.Group != "" {
+		r.resources[res.group.Resource+"."+res.group.Group] = true
+	}
+
+	switch res.group.Group + "/" + res.group.Resource {
+	case "/persistentvolumeclaims":
+		if volume, _, _ := unstructured.NestedString(obj, "spec", "volumeName"); volume != "" {
+			r.volumes[volume] = true
+		}
+		if class, _, _ := unstructured.NestedString(obj, "spec", "storageClassName"); class != "" {
+			r.storageClasses[class] = true
+		}
+
+	case "rbac.authorization.k8s.io/rolebindings":
+		if kind, _, _ := unstructured.NestedString(obj, "roleRef", "kind"); kind == "ClusterRole" {
+			name, _, _ := unstructured.NestedString(obj, "roleRef", "name")
+			r.clusterRoles[name] = true
+		}
+	}
+}
+
+// Related returns deferred objects related to dumped namespaces
+func (r *relatedObjects) Related() []ResourceAndGroup {
+	var related []ResourceAndGroup
+
+	// first pass: objects referencing namespaces directly, they can reference other cluster objects
+	for _, res := range r.pending {
+		obj := res.resource.Object
+
+		switch res.group.Group + "/" + res.group.Resource {
+		case "/namespaces":
+			if r.isNamespaceIncluded(res.resource.GetName()) {
+				related = append(related, res)
+			}
+
+		case "rbac.authorization.k8s.io/clusterrolebindings":
+			subjects, _, _ := unstructured.NestedSlice(obj, "subjects")
+			for _, s := range subjects {
+				subject, ok := s.(map[string]interface{})
+				if !ok {
+					continue
+				}
+
+				ns, _, _ := unstructured.NestedString(subject, "namespace")
+				if r.isNamespaceIncluded(ns) {
+					related = append(related, res)
+
+					if kind, _, _ := unstructured.NestedString(obj, "roleRef", "kind"); kind == "ClusterRole" {
+						name, _, _ := unstructured.NestedString(obj, "roleRef", "name")
+						r.clusterRoles[name] = true
+					}
+					break
+				}
+			}
+
+		case "/persistentvolumes":
+			claimNs, _, _ := unstructured.NestedString(obj, "spec", "claimRef", "namespace")
+			if r.volumes[res.resource.GetName()] || r.isNamespaceIncluded(claimNs) {
+				related = append(related, res)
+
+				if class, _, _ := unstructured.NestedString(obj, "spec", "storageClassName"); class != "" {
+					r.storageClasses[class] = true
+				}
+			}
+		}
+	}
+
+	// second pass: objects referenced by namespaced objects or by the first pass
+	for _, res := range r.pending {
+		name := res.resource.GetName()
+
+		switch res.group.Group + "/" + res.group.Resource {
+		case "rbac.authorization.k8s.io/clusterroles":
+			if r.clusterRoles[name] {
+				related = append(related, res)
+			}
+
+		case "storage.k8s.io/storageclasses":
+			if r.storageClasses[name] {
+				related = append(related, res)
+			}
+
+		case "apiextensions.k8s.io/customresourcedefinitions":
+			if r.resources[name] {
+				related = append(related, res)
+			}
+		}
+	}
+
+	return related
+}
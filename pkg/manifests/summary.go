@@ -0,0 +1,89 @@
+package manifests
+
+import (
+	"fmt"
+	"github.com/ThunderAl197/kubedump/pkg/metrics"
+	"github.com/ThunderAl197/kubedump/pkg/report"
+	"io"
+	"sort"
+	"sync"
+)
+
+type summaryEntry struct {
+	count int
+	err   error
+}
+
+// Summary collects per-resource results of a dump
+type Summary struct {
+	mu      sync.Mutex
+	entries map[string]*summaryEntry
+}
+
+func NewSummary() *Summary {
+	return &Summary{entries: make(map[string]*summaryEntry)}
+}
+
+func (s *Summary) entry(resource string) *summaryEntry {
+	e, ok := s.entries[resource]
+	if !ok {
+		e = &summaryEntry{}
+		s.entries[resource] = e
+	}
+
+	return e
+}
+
+func (s *Summary) Add(resource string, count int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entry(resource).count += count
+}
+
+func (s *Summary) Fail(resource string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entry(resource).err = err
+
+	metrics.Errors.WithLabelValues("manifests").Inc()
+}
+
+func (s *Summary) HasErrors() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range s.entries {
+		if e.err != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Summary) Print(w io.Writer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var names []string
+	for name := range s.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	table := report.NewTable("RESOURCE", "COUNT", "ERROR")
+	for _, name := range names {
+		e := s.entries[name]
+
+		errText := "-"
+		if e.err != nil {
+			errText = e.err.Error()
+		}
+
+		table.Add(name, fmt.Sprint(e.count), errText)
+	}
+
+	return table.Print(w)
+}
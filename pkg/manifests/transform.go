@@ -0,0 +1,199 @@
+package manifests
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ThunderAl197/kubedump/pkg/k8s"
+	jsonpatch "github.com/evanphx/json-patch"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/util/jsonpath"
+	"os"
+	"sigs.k8s.io/yaml"
+)
+
+// TransformRules is a file with user defined modifications applied to objects before writing
+type TransformRules struct {
+	Rules []*TransformRule `json:"rules"`
+}
+
+// TransformRule patches objects matching all its selectors
+type TransformRule struct {
+	Name  string         `json:"name"`
+	Match TransformMatch `json:"match"`
+	// Select is a JSONPath expression, rule is applied only if it finds anything in the object
+	Select string `json:"select"`
+	// JsonPatch is a list of RFC 6902 operations
+	JsonPatch []map[string]interface{} `json:"jsonPatch"`
+	// StrategicMerge is a patch snippet. Custom resources fall back to JSON merge patch
+	StrategicMerge map[string]interface{} `json:"strategicMerge"`
+
+	selector *jsonpath.JSONPath
+	patch    jsonpatch.Patch
+	merge    []byte
+}
+
+// TransformMatch limits rule to objects of given groups, kinds and namespaces. Empty lists match everything.
+// Core group is matched as "core"
+type TransformMatch struct {
+	Groups     []string `json:"groups"`
+	Kinds      []string `json:"kinds"`
+	Namespaces []string `json:"namespaces"`
+	Names      []string `json:"names"`
+}
+
+func LoadTransformRules(fileName string) ([]*TransformRule, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	var file TransformRules
+	err = yaml.UnmarshalStrict(data, &file)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse transform rules %s: %w", fileName, err)
+	}
+
+	for i, rule := range file.Rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("#%d", i+1)
+		}
+
+		err = rule.compile()
+		if err != nil {
+			return nil, fmt.Errorf("invalid transform rule %s: %w", rule.Name, err)
+		}
+	}
+
+	return file.Rules, nil
+}
+
+func (r *TransformRule) compile() error {
+	if r.Select != "" {
+		r.selector = jsonpath.New(r.Name).AllowMissingKeys(true)
+		err := r.selector.Parse(r.Select)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(r.JsonPatch) > 0 {
+		data, err := json.Marshal(r.JsonPatch)
+		if err != nil {
+			return err
+		}
+
+		r.patch, err = jsonpatch.DecodePatch(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(r.StrategicMerge) > 0 {
+		data, err := json.Marshal(r.StrategicMerge)
+		if err != nil {
+			return err
+		}
+
+		r.merge = data
+	}
+
+	return nil
+}
+
+func (r *TransformRule) matches(res ResourceAndGroup) (bool, error) {
+	group := res.group.Group
+	if group == "" {
+		group = "core"
+	}
+
+	if !k8s.IsIncluded(group, r.Match.Groups, nil) ||
+		!k8s.IsIncluded(res.resource.GetKind(), r.Match.Kinds, nil) ||
+		!k8s.IsIncluded(res.resource.GetNamespace(), r.Match.Namespaces, nil) ||
+		!k8s.IsIncluded(res.resource.GetName(), r.Match.Names, nil) {
+		return false, nil
+	}
+
+	if r.selector == nil {
+		return true, nil
+	}
+
+	results, err := r.selector.FindResults(res.resource.Object)
+	if err != nil {
+		return false, err
+	}
+
+	for _, result := range results {
+		if len(result) > 0 {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (r *TransformRule) apply(res ResourceAndGroup, doc []byte) ([]byte, error) {
+	var err error
+
+	if r.patch != nil {
+		doc, err = r.patch.Apply(doc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if r.merge != nil {
+		gvk := schema.FromAPIVersionAndKind(res.resource.GetAPIVersion(), res.resource.GetKind())
+
+		typed, err := scheme.Scheme.New(gvk)
+		if err == nil {
+			doc, err = strategicpatch.StrategicMergePatch(doc, r.merge, typed)
+		} else {
+			doc, err = jsonpatch.MergePatch(doc, r.merge)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return doc, nil
+}
+
+// transformObject applies all matching rules to the object in order they are defined
+func transformObject(rules []*TransformRule, res *ResourceAndGroup) error {
+	var doc []byte
+
+	for _, rule := range rules {
+		ok, err := rule.matches(*res)
+		if err != nil {
+			return fmt.Errorf("transform rule %s: %w", rule.Name, err)
+		}
+		if !ok {
+			continue
+		}
+
+		if doc == nil {
+			doc, err = res.resource.MarshalJSON()
+			if err != nil {
+				return err
+			}
+		}
+
+		doc, err = rule.apply(*res, doc)
+		if err != nil {
+			return fmt.Errorf("transform rule %s on %s %s/%s: %w", rule.Name, res.group, res.resource.GetNamespace(), res.resource.GetName(), err)
+		}
+
+		// following rules must see the patched object
+		obj := unstructured.Unstructured{}
+		err = obj.UnmarshalJSON(doc)
+		if err != nil {
+			return err
+		}
+		res.resource = obj
+	}
+
+	return nil
+}
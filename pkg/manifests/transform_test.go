@@ -0,0 +1,39 @@
+package manifests
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFailedTransformIsSkipped(t *testing.T) {
+	rule := &TransformRule{
+		Name:      "remove-missing",
+		JsonPatch: []map[string]interface{}{{"op": "remove", "path": "/metadata/labels/missing"}},
+	}
+
+	err := rule.compile()
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+
+	res := ResourceAndGroup{group: configMaps, resource: *newConfigMap("app", "1")}
+
+	err = writeObject(&CommandArgs{DryRun: true}, res, []*TransformRule{rule}, nil)
+	if !errors.Is(err, errTransformFailed) {
+		t.Fatalf("got error %v, want %v", err, errTransformFailed)
+	}
+
+	summary := NewSummary()
+
+	if skipErr := skipFailedTransform(&CommandArgs{}, summary, res, err); skipErr != nil {
+		t.Errorf("object was not skipped: %v", skipErr)
+	}
+
+	if !summary.HasErrors() {
+		t.Error("failed object is not recorded in the summary")
+	}
+
+	if skipErr := skipFailedTransform(&CommandArgs{FailFast: true}, NewSummary(), res, err); skipErr == nil {
+		t.Error("--fail-fast did not abort the dump")
+	}
+}
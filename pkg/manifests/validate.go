@@ -0,0 +1,385 @@
+package manifests
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/urfave/cli"
+	"io/fs"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/kube-openapi/pkg/spec3"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+	"k8s.io/kube-openapi/pkg/validation/strfmt"
+	"k8s.io/kube-openapi/pkg/validation/validate"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strings"
+)
+
+type openApiSchema struct {
+	schema *spec.Schema
+	// components of the document the schema is defined in, used to resolve references
+	components map[string]*spec.Schema
+}
+
+// schemaRegistry finds schemas by group version kind in saved OpenAPI documents and dumped CRDs
+type schemaRegistry struct {
+	schemas  map[schema.GroupVersionKind]openApiSchema
+	expanded map[schema.GroupVersionKind]*spec.Schema
+}
+
+func GetValidateCliCommand() cli.Command {
+	return cli.Command{
+		Name:      "validate",
+		Usage:     "Validate dumped manifests against OpenAPI schema saved with --save-openapi",
+		ArgsUsage: "dump directory",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "openapi",
+				Usage: "Path to directory with OpenAPI v3 documents. By default {dump directory}/" + openApiDir,
+			},
+			cli.BoolFlag{
+				Name:  "fail-unvalidated",
+				Usage: "Fail if some manifests cannot be validated because their kind has no schema",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 1 {
+				return errors.New("dump directory is required")
+			}
+
+			dir := c.Args().First()
+
+			openApi := c.String("openapi")
+			if openApi == "" {
+				openApi = filepath.Join(dir, filepath.FromSlash(openApiDir))
+			}
+
+			return Validate(dir, openApi, c.Bool("fail-unvalidated"))
+		},
+	}
+}
+
+// Validate checks every manifest in the dump against the schema of its kind. Manifests of kinds
+// without schema are reported as unvalidated and fail the check only with failUnvalidated
+func Validate(dir string, openApiDir string, failUnvalidated bool) error {
+	registry := &schemaRegistry{
+		schemas:  make(map[schema.GroupVersionKind]openApiSchema),
+		expanded: make(map[schema.GroupVersionKind]*spec.Schema),
+	}
+
+	err := registry.loadOpenApi(openApiDir)
+	if err != nil {
+		return err
+	}
+
+	var objects []*unstructured.Unstructured
+	files := make(map[*unstructured.Unstructured]string)
+
+	err = filepath.WalkDir(dir, func(fileName string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() {
+			rel, _ := filepath.Rel(dir, fileName)
+			if rel == "openapi" || rel == "helm" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		ext := filepath.Ext(fileName)
+		if ext != ".yaml" && ext != ".yml" {
+			return nil
+		}
+
+		obj, err := readManifest(fileName)
+		if err != nil {
+			slog.Warn("Skipping file", "file", fileName, "error", err)
+			return nil
+		}
+		if obj == nil {
+			return nil
+		}
+
+		objects = append(objects, obj)
+		files[obj] = fileName
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// custom resources without published schema are checked against dumped crds
+	for _, obj := range objects {
+		if obj.GetKind() == "CustomResourceDefinition" && obj.GroupVersionKind().Group == crdResource.Group {
+			registry.addCrd(obj)
+		}
+	}
+
+	var (
+		invalid     int
+		unvalidated int
+	)
+
+	sort.Slice(objects, func(i, j int) bool {
+		return files[objects[i]] < files[objects[j]]
+	})
+
+	for _, obj := range objects {
+		gvk := obj.GroupVersionKind()
+
+		s := registry.get(gvk)
+		if s == nil {
+			unvalidated++
+			fmt.Printf("%s: unvalidated, no schema for %s\n", files[obj], gvk)
+			continue
+		}
+
+		result := validate.NewSchemaValidator(s, nil, "", strfmt.Default).Validate(obj.Object)
+		if !result.HasErrors() {
+			continue
+		}
+
+		invalid++
+		for _, err := range result.Errors {
+			fmt.Printf("%s: %s\n", files[obj], err)
+		}
+	}
+
+	fmt.Printf("\n%d manifests checked, %d invalid, %d unvalidated\n", len(objects), invalid, unvalidated)
+
+	if invalid > 0 {
+		return fmt.Errorf("%d manifests would be rejected", invalid)
+	}
+
+	if failUnvalidated && unvalidated > 0 {
+		return fmt.Errorf("%d manifests have no schema", unvalidated)
+	}
+
+	return nil
+}
+
+// readManifest returns nil for yaml files which are not kubernetes objects
+func readManifest(fileName string) (*unstructured.Unstructured, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	var obj map[string]interface{}
+	err = yaml.Unmarshal(data, &obj)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &unstructured.Unstructured{Object: obj}
+	if u.GetAPIVersion() == "" || u.GetKind() == "" || u.GetName() == "" {
+		return nil, nil
+	}
+
+	return u, nil
+}
+
+func (r *schemaRegistry) loadOpenApi(dir string) error {
+	return filepath.WalkDir(dir, func(fileName string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() || filepath.Ext(fileName) != ".json" {
+			return nil
+		}
+
+		data, err := os.ReadFile(fileName)
+		if err != nil {
+			return err
+		}
+
+		var doc spec3.OpenAPI
+		err = json.Unmarshal(data, &doc)
+		if err != nil {
+			return fmt.Errorf("cannot parse %s: %w", fileName, err)
+		}
+
+		if doc.Components == nil {
+			return nil
+		}
+
+		for _, s := range doc.Components.Schemas {
+			gvks, ok := s.Extensions["x-kubernetes-group-version-kind"].([]interface{})
+			if !ok {
+				continue
+			}
+
+			for _, item := range gvks {
+				gvk, ok := item.(map[string]interface{})
+				if !ok {
+					continue
+				}
+
+				key := schema.GroupVersionKind{
+					Group:   fmt.Sprint(gvk["group"]),
+					Version: fmt.Sprint(gvk["version"]),
+					Kind:    fmt.Sprint(gvk["kind"]),
+				}
+
+				// meta types like DeleteOptions are registered for every group, keep the own one
+				if _, exists := r.schemas[key]; !exists {
+					r.schemas[key] = openApiSchema{schema: s, components: doc.Components.Schemas}
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+func (r *schemaRegistry) addCrd(crd *unstructured.Unstructured) {
+	group, _, _ := unstructured.NestedString(crd.Object, "spec", "group")
+	kind, _, _ := unstructured.NestedString(crd.Object, "spec", "names", "kind")
+	versions, _, _ := unstructured.NestedSlice(crd.Object, "spec", "versions")
+
+	for _, v := range versions {
+		version, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		name, _, _ := unstructured.NestedString(version, "name")
+		key := schema.GroupVersionKind{Group: group, Version: name, Kind: kind}
+		if _, exists := r.schemas[key]; exists {
+			continue
+		}
+
+		raw, ok, _ := unstructured.NestedMap(version, "schema", "openAPIV3Schema")
+		if !ok {
+			continue
+		}
+
+		data, err := json.Marshal(raw)
+		if err != nil {
+			continue
+		}
+
+		s := &spec.Schema{}
+		err = json.Unmarshal(data, s)
+		if err != nil {
+			slog.Warn("Cannot parse crd schema", "kind", key.String(), "error", err)
+			continue
+		}
+
+		// crd schemas may omit object meta fields
+		for field, fieldType := range map[string]string{"apiVersion": "string", "kind": "string", "metadata": "object"} {
+			if _, ok := s.Properties[field]; !ok {
+				if s.Properties == nil {
+					s.Properties = make(map[string]spec.Schema)
+				}
+				s.Properties[field] = spec.Schema{SchemaProps: spec.SchemaProps{Type: spec.StringOrArray{fieldType}}}
+			}
+		}
+
+		r.schemas[key] = openApiSchema{schema: s}
+	}
+}
+
+func (r *schemaRegistry) get(gvk schema.GroupVersionKind) *spec.Schema {
+	if s, ok := r.expanded[gvk]; ok {
+		return s
+	}
+
+	s, ok := r.schemas[gvk]
+	if !ok {
+		return nil
+	}
+
+	expanded := expandSchema(s.schema, s.components, make(map[string]bool))
+	r.expanded[gvk] = expanded
+
+	return expanded
+}
+
+// expandSchema resolves references, because validator does not support them. Recursive references
+// are replaced with schema accepting anything. Objects with known properties reject unknown fields
+// the same way as strict field validation of api server does
+func expandSchema(s *spec.Schema, components map[string]*spec.Schema, stack map[string]bool) *spec.Schema {
+	if s == nil {
+		return nil
+	}
+
+	if ref := s.Ref.String(); ref != "" {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+
+		target, ok := components[name]
+		if !ok || stack[name] {
+			return &spec.Schema{}
+		}
+
+		stack[name] = true
+		defer delete(stack, name)
+
+		return expandSchema(target, components, stack)
+	}
+
+	out := *s
+
+	expandList := func(list []spec.Schema) []spec.Schema {
+		if list == nil {
+			return nil
+		}
+
+		result := make([]spec.Schema, len(list))
+		for i := range list {
+			result[i] = *expandSchema(&list[i], components, stack)
+		}
+		return result
+	}
+
+	out.AllOf = expandList(s.AllOf)
+	out.AnyOf = expandList(s.AnyOf)
+	out.OneOf = expandList(s.OneOf)
+	out.Not = expandSchema(s.Not, components, stack)
+
+	if s.Properties != nil {
+		out.Properties = make(map[string]spec.Schema, len(s.Properties))
+		for name := range s.Properties {
+			prop := s.Properties[name]
+			out.Properties[name] = *expandSchema(&prop, components, stack)
+		}
+	}
+
+	if s.AdditionalProperties != nil {
+		out.AdditionalProperties = &spec.SchemaOrBool{
+			Allows: s.AdditionalProperties.Allows,
+			Schema: expandSchema(s.AdditionalProperties.Schema, components, stack),
+		}
+	}
+
+	if s.Items != nil {
+		out.Items = &spec.SchemaOrArray{
+			Schema:  expandSchema(s.Items.Schema, components, stack),
+			Schemas: expandList(s.Items.Schemas),
+		}
+	}
+
+	if intOrString, _ := s.Extensions["x-kubernetes-int-or-string"].(bool); intOrString {
+		out.Type = nil
+		out.AnyOf = []spec.Schema{
+			{SchemaProps: spec.SchemaProps{Type: spec.StringOrArray{"integer"}}},
+			{SchemaProps: spec.SchemaProps{Type: spec.StringOrArray{"string"}}},
+		}
+	}
+
+	preserveUnknown, _ := s.Extensions["x-kubernetes-preserve-unknown-fields"].(bool)
+	if len(s.Properties) > 0 && s.AdditionalProperties == nil && !preserveUnknown {
+		out.AdditionalProperties = &spec.SchemaOrBool{Allows: false}
+	}
+
+	return &out
+}
@@ -0,0 +1,33 @@
+package manifests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateUnvalidated(t *testing.T) {
+	dir := t.TempDir()
+	openApi := filepath.Join(dir, "openapi")
+
+	err := os.MkdirAll(openApi, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest := "apiVersion: example.com/v1\nkind: Widget\nmetadata:\n  name: widget\n"
+	err = os.WriteFile(filepath.Join(dir, "widget.yaml"), []byte(manifest), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Validate(dir, openApi, false)
+	if err != nil {
+		t.Errorf("manifest without schema failed validation: %v", err)
+	}
+
+	err = Validate(dir, openApi, true)
+	if err == nil {
+		t.Error("manifest without schema passed validation with failUnvalidated")
+	}
+}
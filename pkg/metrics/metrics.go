@@ -0,0 +1,155 @@
+package metrics
+
+import (
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+var registry = prometheus.NewRegistry()
+
+var (
+	ObjectsDumped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kubedump",
+		Name:      "objects_dumped_total",
+		Help:      "Number of dumped objects by resource",
+	}, []string{"resource"})
+
+	BytesWritten = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kubedump",
+		Name:      "bytes_written_total",
+		Help:      "Number of bytes written to the output by command",
+	}, []string{"command"})
+
+	VolumesDownloaded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kubedump",
+		Name:      "volumes_downloaded_total",
+		Help:      "Number of processed volumes by status",
+	}, []string{"status"})
+
+	VolumeDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "kubedump",
+		Name:      "volume_download_duration_seconds",
+		Help:      "Time spent to download a single volume",
+		Buckets:   prometheus.ExponentialBuckets(1, 4, 10),
+	})
+
+	Errors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kubedump",
+		Name:      "errors_total",
+		Help:      "Number of skipped resources or volumes by command",
+	}, []string{"command"})
+
+	RunDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kubedump",
+		Name:      "run_duration_seconds",
+		Help:      "Duration of the last run by command",
+	}, []string{"command"})
+
+	LastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kubedump",
+		Name:      "last_success_timestamp_seconds",
+		Help:      "Unix time of the last successful run by command",
+	}, []string{"command"})
+)
+
+func init() {
+	registry.MustRegister(
+		ObjectsDumped,
+		BytesWritten,
+		VolumesDownloaded,
+		VolumeDuration,
+		Errors,
+		RunDuration,
+		LastSuccess,
+	)
+}
+
+type Config struct {
+	// Command is a full name of running command used as a label, it is set by SetCommand
+	Command string
+	// ListenAddr enables /metrics endpoint during the run
+	ListenAddr string
+	// PushgatewayUrl enables push of metrics at completion
+	PushgatewayUrl string
+	Job            string
+	// Textfile is a path for node exporter textfile collector
+	Textfile string
+}
+
+var (
+	config    Config
+	startedAt time.Time
+)
+
+// Start remembers configuration and serves /metrics if enabled
+func Start(cfg Config) error {
+	config = cfg
+	startedAt = time.Now()
+
+	if cfg.ListenAddr == "" {
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	server := &http.Server{
+		Addr:              cfg.ListenAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Metrics server failed", "addr", cfg.ListenAddr, "error", err)
+		}
+	}()
+
+	slog.Info("Serving metrics", "addr", cfg.ListenAddr)
+
+	return nil
+}
+
+// SetCommand sets command label once the command is resolved
+func SetCommand(command string) {
+	config.Command = command
+}
+
+// Finish records run duration and result, then pushes metrics and writes textfile if enabled
+func Finish(runErr error) error {
+	if config.Command == "" {
+		return nil
+	}
+
+	RunDuration.WithLabelValues(config.Command).Set(time.Since(startedAt).Seconds())
+	if runErr == nil {
+		LastSuccess.WithLabelValues(config.Command).SetToCurrentTime()
+	}
+
+	var err error
+
+	if config.PushgatewayUrl != "" {
+		err = push.New(config.PushgatewayUrl, config.Job).
+			Grouping("command", config.Command).
+			Gatherer(registry).
+			Push()
+		if err != nil {
+			return err
+		}
+	}
+
+	if config.Textfile != "" {
+		err = prometheus.WriteToTextfile(config.Textfile, registry)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package output
+
+import (
+	"log/slog"
+	"os"
+	"path"
+)
+
+type local struct {
+	dir         string
+	keepPartial bool
+}
+
+func NewLocal(dir string, keepPartial bool) Backend {
+	return &local{dir: dir, keepPartial: keepPartial}
+}
+
+func (l *local) String() string {
+	return l.dir
+}
+
+func (l *local) Create(name string) (FileWriter, error) {
+	filePath := path.Join(l.dir, name)
+
+	err := os.MkdirAll(path.Dir(filePath), 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	// file is written under temporary name, so failed write never looks like a complete one
+	file, err := os.OpenFile(filePath+".partial", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &localWriter{File: file, target: filePath, keepPartial: l.keepPartial}, nil
+}
+
+func (l *local) WriteFile(name string, data []byte) error {
+	filePath := path.Join(l.dir, name)
+
+	err := os.MkdirAll(path.Dir(filePath), 0700)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filePath, data, 0600)
+}
+
+func (l *local) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(path.Join(l.dir, name))
+}
+
+type localWriter struct {
+	*os.File
+	target      string
+	keepPartial bool
+}
+
+func (w *localWriter) Close() error {
+	err := w.File.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(w.File.Name(), w.target)
+}
+
+func (w *localWriter) Abort() error {
+	_ = w.File.Close()
+
+	if w.keepPartial {
+		slog.Warn("Partial file kept", "file", w.File.Name())
+		return nil
+	}
+
+	return os.Remove(w.File.Name())
+}
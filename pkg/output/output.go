@@ -0,0 +1,78 @@
+package output
+
+import (
+	"context"
+	"github.com/urfave/cli"
+	"io"
+	"strings"
+)
+
+// Backend stores files of a dump. Names are slash separated paths relative to the dump root
+type Backend interface {
+	// Create returns writer streaming into file. File is complete only after Close, Abort discards it
+	Create(name string) (FileWriter, error)
+	WriteFile(name string, data []byte) error
+	// ReadFile returns error matching os.ErrNotExist if file is missing
+	ReadFile(name string) ([]byte, error)
+	String() string
+}
+
+type FileWriter interface {
+	io.Writer
+	Close() error
+	Abort() error
+}
+
+type Config struct {
+	// KeepPartial keeps aborted local files with .partial suffix
+	KeepPartial bool
+
+	S3Endpoint string
+	S3Region   string
+	S3Insecure bool
+	S3PartSize uint64
+}
+
+func GetCliFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:   "s3-endpoint",
+			EnvVar: "KUBEDUMP_S3_ENDPOINT",
+			Usage:  "S3 compatible endpoint used for s3:// output. Credentials are read from AWS_* or MINIO_* env variables, aws config or instance metadata",
+			Value:  "s3.amazonaws.com",
+		},
+		cli.StringFlag{
+			Name:   "s3-region",
+			EnvVar: "KUBEDUMP_S3_REGION",
+			Usage:  "S3 bucket region. By default it is detected",
+		},
+		cli.BoolFlag{
+			Name:   "s3-insecure",
+			EnvVar: "KUBEDUMP_S3_INSECURE",
+			Usage:  "Use plain http for S3 endpoint",
+		},
+		cli.IntFlag{
+			Name:  "s3-part-size",
+			Usage: "Multipart upload part size in MiB. Object size is limited to 10000 parts",
+			Value: 64,
+		},
+	}
+}
+
+func GetConfig(c *cli.Context) Config {
+	return Config{
+		S3Endpoint: c.String("s3-endpoint"),
+		S3Region:   c.String("s3-region"),
+		S3Insecure: c.Bool("s3-insecure"),
+		S3PartSize: uint64(c.Int("s3-part-size")) * 1024 * 1024,
+	}
+}
+
+// New returns backend for target, which is a local directory or s3://bucket/prefix
+func New(ctx context.Context, target string, cfg Config) (Backend, error) {
+	if location, ok := strings.CutPrefix(target, "s3://"); ok {
+		return newS3(ctx, location, cfg)
+	}
+
+	return NewLocal(target, cfg.KeepPartial), nil
+}
@@ -0,0 +1,160 @@
+package output
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+var errAborted = errors.New("upload aborted")
+
+type s3 struct {
+	ctx      context.Context
+	client   *minio.Client
+	bucket   string
+	prefix   string
+	partSize uint64
+}
+
+func newS3(ctx context.Context, location string, cfg Config) (Backend, error) {
+	bucket, prefix, _ := strings.Cut(location, "/")
+	if bucket == "" {
+		return nil, fmt.Errorf("s3 output must be in form s3://bucket/prefix")
+	}
+
+	client, err := minio.New(cfg.S3Endpoint, &minio.Options{
+		Creds: credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.EnvMinio{},
+			&credentials.FileAWSCredentials{},
+			&credentials.IAM{},
+		}),
+		Secure: !cfg.S3Insecure,
+		Region: cfg.S3Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	exists, err := client.BucketExists(ctx, bucket)
+	if err != nil {
+		return nil, fmt.Errorf("cannot access bucket %s: %w", bucket, err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("bucket %s does not exist", bucket)
+	}
+
+	return &s3{
+		ctx:      ctx,
+		client:   client,
+		bucket:   bucket,
+		prefix:   strings.Trim(prefix, "/"),
+		partSize: cfg.S3PartSize,
+	}, nil
+}
+
+func (s *s3) String() string {
+	return "s3://" + path.Join(s.bucket, s.prefix)
+}
+
+func (s *s3) key(name string) string {
+	return path.Join(s.prefix, name)
+}
+
+// Create streams data with multipart upload, so object size does not need to be known
+func (s *s3) Create(name string) (FileWriter, error) {
+	key := s.key(name)
+
+	upload := func(r io.Reader) error {
+		_, err := s.client.PutObject(s.ctx, s.bucket, key, r, -1, minio.PutObjectOptions{
+			PartSize:    s.partSize,
+			ContentType: "application/octet-stream",
+		})
+
+		return err
+	}
+
+	remove := func() error {
+		return s.client.RemoveObject(s.ctx, s.bucket, key, minio.RemoveObjectOptions{})
+	}
+
+	return newS3Writer(upload, remove), nil
+}
+
+func (s *s3) WriteFile(name string, data []byte) error {
+	_, err := s.client.PutObject(s.ctx, s.bucket, s.key(name), bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{})
+
+	return err
+}
+
+func (s *s3) ReadFile(name string) ([]byte, error) {
+	object, err := s.client.GetObject(s.ctx, s.bucket, s.key(name), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+
+	data, err := io.ReadAll(object)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, fmt.Errorf("%s: %w", name, os.ErrNotExist)
+		}
+
+		return nil, err
+	}
+
+	return data, nil
+}
+
+type s3Writer struct {
+	pipe   *io.PipeWriter
+	done   chan error
+	remove func() error
+}
+
+// newS3Writer runs upload reading everything written to the writer. Remove deletes uploaded object
+func newS3Writer(upload func(r io.Reader) error, remove func() error) *s3Writer {
+	reader, writer := io.Pipe()
+
+	w := &s3Writer{pipe: writer, done: make(chan error, 1), remove: remove}
+
+	go func() {
+		err := upload(reader)
+
+		// unblock writer if upload failed
+		_ = reader.CloseWithError(err)
+		w.done <- err
+	}()
+
+	return w
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	return w.pipe.Write(p)
+}
+
+func (w *s3Writer) Close() error {
+	_ = w.pipe.Close()
+
+	return <-w.done
+}
+
+// Abort fails the upload, so multipart upload is aborted and no object is created. Error of the upload
+// is expected then and is not returned. Object is removed if upload completed before it saw the abort
+func (w *s3Writer) Abort() error {
+	_ = w.pipe.CloseWithError(errAborted)
+
+	err := <-w.done
+	if err == nil {
+		return w.remove()
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package output
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// fakeUpload reads the stream like the s3 client and wraps its errors without %w
+type fakeUpload struct {
+	data    bytes.Buffer
+	removed bool
+	// fail is returned after the stream is read, skip finishes upload without reading
+	fail error
+	skip bool
+}
+
+func (f *fakeUpload) upload(r io.Reader) error {
+	if f.skip {
+		return nil
+	}
+
+	_, err := io.Copy(&f.data, r)
+	if err != nil {
+		return fmt.Errorf("upload failed: %v", err)
+	}
+
+	return f.fail
+}
+
+func (f *fakeUpload) remove() error {
+	f.removed = true
+	return nil
+}
+
+func TestS3WriterClose(t *testing.T) {
+	fake := &fakeUpload{}
+	w := newS3Writer(fake.upload, fake.remove)
+
+	_, err := w.Write([]byte("archive"))
+	if err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	if fake.data.String() != "archive" {
+		t.Errorf("uploaded %q, want %q", fake.data.String(), "archive")
+	}
+}
+
+func TestS3WriterCloseFailed(t *testing.T) {
+	fake := &fakeUpload{fail: errors.New("access denied")}
+	w := newS3Writer(fake.upload, fake.remove)
+
+	err := w.Close()
+	if err == nil {
+		t.Fatal("failed upload is closed without error")
+	}
+}
+
+func TestS3WriterAbort(t *testing.T) {
+	fake := &fakeUpload{}
+	w := newS3Writer(fake.upload, fake.remove)
+
+	_, err := w.Write([]byte("partial"))
+	if err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	err = w.Abort()
+	if err != nil {
+		t.Errorf("Abort: %v", err)
+	}
+
+	if fake.removed {
+		t.Error("object of failed upload was removed")
+	}
+}
+
+func TestS3WriterAbortCompletedUpload(t *testing.T) {
+	fake := &fakeUpload{skip: true}
+	w := newS3Writer(fake.upload, fake.remove)
+
+	err := w.Abort()
+	if err != nil {
+		t.Errorf("Abort: %v", err)
+	}
+
+	if !fake.removed {
+		t.Error("completed object is kept after abort")
+	}
+}
@@ -0,0 +1,337 @@
+package progress
+
+import (
+	"fmt"
+	"golang.org/x/term"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	ModeAuto  = "auto"
+	ModeBar   = "bar"
+	ModePlain = "plain"
+	ModeNone  = "none"
+)
+
+type Unit int
+
+const (
+	UnitCount Unit = iota
+	UnitBytes
+)
+
+const (
+	barInterval   = 500 * time.Millisecond
+	plainInterval = 30 * time.Second
+	barWidth      = 20
+)
+
+// Task is a single tracked job. Write counts processed units, so task can be used as io.Writer
+type Task struct {
+	name  string
+	unit  Unit
+	total atomic.Int64
+	done  atomic.Int64
+
+	mu         sync.Mutex
+	startedAt  time.Time
+	finishedAt time.Time
+}
+
+func (t *Task) Start() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.startedAt = time.Now()
+}
+
+func (t *Task) Finish() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.finishedAt = time.Now()
+}
+
+func (t *Task) SetTotal(total int64) {
+	t.total.Store(total)
+}
+
+func (t *Task) Add(n int64) {
+	t.done.Add(n)
+}
+
+func (t *Task) Write(p []byte) (int, error) {
+	t.done.Add(int64(len(p)))
+	return len(p), nil
+}
+
+func (t *Task) state() (started, finished bool, elapsed time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.startedAt.IsZero() {
+		return false, false, 0
+	}
+
+	if !t.finishedAt.IsZero() {
+		return true, true, t.finishedAt.Sub(t.startedAt)
+	}
+
+	return true, false, time.Since(t.startedAt)
+}
+
+// Tracker periodically renders state of tasks as terminal bars or as plain log lines
+type Tracker struct {
+	mode      string
+	mu        sync.Mutex
+	tasks     []*Task
+	startedAt time.Time
+	stop      chan struct{}
+	stopped   chan struct{}
+}
+
+// New creates tracker. Auto mode selects bars if stderr is a terminal and plain lines otherwise
+func New(mode string) (*Tracker, error) {
+	switch mode {
+	case ModeAuto:
+		mode = ModePlain
+		if term.IsTerminal(int(os.Stderr.Fd())) {
+			mode = ModeBar
+		}
+	case ModeBar, ModePlain, ModeNone:
+	default:
+		return nil, fmt.Errorf("unknown progress mode %q", mode)
+	}
+
+	return &Tracker{mode: mode}, nil
+}
+
+func (tr *Tracker) Task(name string, total int64, unit Unit) *Task {
+	task := &Task{name: name, unit: unit}
+	task.total.Store(total)
+
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	tr.tasks = append(tr.tasks, task)
+
+	return task
+}
+
+func (tr *Tracker) Start() {
+	tr.startedAt = time.Now()
+
+	if tr.mode == ModeNone {
+		return
+	}
+
+	interval := plainInterval
+	if tr.mode == ModeBar {
+		interval = barInterval
+	}
+
+	tr.stop = make(chan struct{})
+	tr.stopped = make(chan struct{})
+
+	go func() {
+		defer close(tr.stopped)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-tr.stop:
+				if tr.mode == ModeBar {
+					Stderr.draw(nil)
+				}
+				return
+			case <-ticker.C:
+				tr.render()
+			}
+		}
+	}()
+}
+
+func (tr *Tracker) Stop() {
+	if tr.stop == nil {
+		return
+	}
+
+	close(tr.stop)
+	<-tr.stopped
+	tr.stop = nil
+}
+
+func (tr *Tracker) render() {
+	tr.mu.Lock()
+	tasks := append([]*Task(nil), tr.tasks...)
+	tr.mu.Unlock()
+
+	var (
+		lines               []string
+		finished, active    int
+		done, total, remain int64
+		unit                = UnitCount
+	)
+
+	for _, task := range tasks {
+		started, isFinished, elapsed := task.state()
+
+		taskDone, taskTotal := task.done.Load(), task.total.Load()
+		done += taskDone
+		total += taskTotal
+		unit = task.unit
+
+		if isFinished {
+			finished++
+			continue
+		}
+
+		if taskTotal > taskDone {
+			remain += taskTotal - taskDone
+		}
+
+		if !started {
+			continue
+		}
+
+		active++
+
+		if tr.mode == ModeBar {
+			lines = append(lines, formatBar(task, elapsed))
+		} else {
+			slog.Info("Progress", task.attrs(elapsed)...)
+		}
+	}
+
+	if len(tasks) < 2 {
+		if tr.mode == ModeBar {
+			Stderr.draw(lines)
+		}
+		return
+	}
+
+	elapsed := time.Since(tr.startedAt)
+	rate := float64(done) / elapsed.Seconds()
+
+	summary := []any{
+		"finished", fmt.Sprintf("%d/%d", finished, len(tasks)),
+		"active", active,
+		"done", formatAmount(done, unit),
+		"rate", formatRate(rate, unit),
+	}
+	if total > 0 {
+		summary = append(summary, "eta", formatEta(remain, rate))
+	}
+
+	if tr.mode == ModeBar {
+		var parts []string
+		for i := 0; i < len(summary); i += 2 {
+			parts = append(parts, fmt.Sprintf("%s %v", summary[i], summary[i+1]))
+		}
+		lines = append(lines, "total: "+strings.Join(parts, ", "))
+		Stderr.draw(lines)
+	} else {
+		slog.Info("Total progress", summary...)
+	}
+}
+
+func (t *Task) attrs(elapsed time.Duration) []any {
+	done, total := t.done.Load(), t.total.Load()
+	rate := float64(done) / elapsed.Seconds()
+
+	attrs := []any{"task", t.name, "done", formatAmount(done, t.unit)}
+	if total > 0 {
+		attrs = append(attrs,
+			"total", formatAmount(total, t.unit),
+			"percent", percent(done, total),
+		)
+	}
+	attrs = append(attrs, "rate", formatRate(rate, t.unit))
+	if total > 0 {
+		attrs = append(attrs, "eta", formatEta(total-done, rate))
+	}
+
+	return attrs
+}
+
+func formatBar(t *Task, elapsed time.Duration) string {
+	done, total := t.done.Load(), t.total.Load()
+	rate := float64(done) / elapsed.Seconds()
+
+	var sb strings.Builder
+	sb.WriteString(t.name)
+
+	if total > 0 {
+		filled := int(int64(barWidth) * min(done, total) / total)
+		fmt.Fprintf(&sb, " [%s%s] %3d%% %s/%s",
+			strings.Repeat("=", filled), strings.Repeat(" ", barWidth-filled),
+			percent(done, total), formatAmount(done, t.unit), formatAmount(total, t.unit))
+	} else {
+		fmt.Fprintf(&sb, " %s", formatAmount(done, t.unit))
+	}
+
+	fmt.Fprintf(&sb, " %s", formatRate(rate, t.unit))
+
+	if total > 0 {
+		fmt.Fprintf(&sb, " ETA %s", formatEta(total-done, rate))
+	}
+
+	return sb.String()
+}
+
+func percent(done, total int64) int64 {
+	return min(100, done*100/total)
+}
+
+func formatAmount(n int64, unit Unit) string {
+	if unit == UnitCount {
+		return fmt.Sprint(n)
+	}
+
+	const k = 1024
+	if n < k {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	value := float64(n)
+	suffixes := []string{"KiB", "MiB", "GiB", "TiB", "PiB"}
+	i := -1
+	for value >= k && i < len(suffixes)-1 {
+		value /= k
+		i++
+	}
+
+	return fmt.Sprintf("%.1f %s", value, suffixes[i])
+}
+
+// FormatBytes formats size with binary unit suffix
+func FormatBytes(n int64) string {
+	return formatAmount(n, UnitBytes)
+}
+
+func formatRate(rate float64, unit Unit) string {
+	if unit == UnitCount {
+		return fmt.Sprintf("%.1f/s", rate)
+	}
+
+	return formatAmount(int64(rate), unit) + "/s"
+}
+
+func formatEta(remain int64, rate float64) string {
+	if remain <= 0 {
+		return "0s"
+	}
+
+	if rate <= 0 {
+		return "unknown"
+	}
+
+	return (time.Duration(float64(remain)/rate) * time.Second).Truncate(time.Second).String()
+}
@@ -0,0 +1,53 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Stderr should be used for all stderr output, so logs dont get mixed with progress bars
+var Stderr = &terminal{out: os.Stderr}
+
+type terminal struct {
+	mu    sync.Mutex
+	out   io.Writer
+	lines int
+}
+
+func (t *terminal) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// bars are drawn again on next tick
+	t.clear()
+
+	return t.out.Write(p)
+}
+
+// draw replaces previously drawn lines
+func (t *terminal) draw(lines []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.clear()
+
+	if len(lines) == 0 {
+		return
+	}
+
+	_, _ = io.WriteString(t.out, strings.Join(lines, "\n")+"\n")
+	t.lines = len(lines)
+}
+
+func (t *terminal) clear() {
+	if t.lines == 0 {
+		return
+	}
+
+	// move cursor up and erase to the end of screen
+	_, _ = fmt.Fprintf(t.out, "\x1b[%dA\x1b[J", t.lines)
+	t.lines = 0
+}
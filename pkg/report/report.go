@@ -0,0 +1,46 @@
+package report
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// ExitCodePartial is returned by the process when a command completed but
+// some items were skipped because of errors
+const ExitCodePartial = 3
+
+var ErrPartial = errors.New("completed with errors, some items were skipped")
+
+type Table struct {
+	header []string
+	rows   [][]string
+}
+
+func NewTable(header ...string) *Table {
+	return &Table{header: header}
+}
+
+func (t *Table) Add(cols ...string) {
+	t.rows = append(t.rows, cols)
+}
+
+func (t *Table) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	_, err := fmt.Fprintln(tw, strings.Join(t.header, "\t"))
+	if err != nil {
+		return err
+	}
+
+	for _, row := range t.rows {
+		_, err = fmt.Fprintln(tw, strings.Join(row, "\t"))
+		if err != nil {
+			return err
+		}
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,66 @@
+package volumes
+
+import (
+	"context"
+	"fmt"
+	"github.com/ThunderAl197/kubedump/pkg/k8s"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"log/slog"
+	"time"
+)
+
+const claimPollInterval = 2 * time.Second
+
+// waitClaimBound waits until claim is bound to a volume, so pod using it can be scheduled.
+// Claims of WaitForFirstConsumer storage classes are bound only after pod is scheduled and are not waited for
+func waitClaimBound(ctx context.Context, claim *v1.PersistentVolumeClaim, timeout time.Duration) error {
+	if claim.Status.Phase == v1.ClaimBound {
+		return nil
+	}
+
+	if claim.Spec.StorageClassName != nil && *claim.Spec.StorageClassName != "" {
+		class, err := k8s.KClient.StorageV1().
+			StorageClasses().
+			Get(ctx, *claim.Spec.StorageClassName, metav1.GetOptions{})
+		if err != nil {
+			slog.Warn("Cannot get storage class, not waiting for pvc to be bound", "pvc", claim.Name, "namespace", claim.Namespace, "class", *claim.Spec.StorageClassName, "error", err)
+			return nil
+		}
+
+		if class.VolumeBindingMode != nil && *class.VolumeBindingMode == storagev1.VolumeBindingWaitForFirstConsumer {
+			return nil
+		}
+	}
+
+	slog.Info("Waiting for pvc to be bound", "pvc", claim.Name, "namespace", claim.Namespace)
+
+	err := wait.PollUntilContextTimeout(ctx, claimPollInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		current, err := k8s.KClient.CoreV1().
+			PersistentVolumeClaims(claim.Namespace).
+			Get(ctx, claim.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+
+		switch current.Status.Phase {
+		case v1.ClaimBound:
+			return true, nil
+		case v1.ClaimLost:
+			return false, fmt.Errorf("pvc %s lost its volume", claim.Name)
+		}
+
+		return false, nil
+	})
+	if err != nil {
+		if ctx.Err() == nil && wait.Interrupted(err) {
+			return fmt.Errorf("pvc %s not bound after %s", claim.Name, timeout)
+		}
+
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,199 @@
+package volumes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ThunderAl197/kubedump/pkg/k8s"
+	"github.com/urfave/cli"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/util/homedir"
+	"log/slog"
+	"path"
+	"time"
+)
+
+type CleanupCommandArgs struct {
+	Kubeconfig     string
+	OnlyNamespaces []string
+	OlderThan      time.Duration
+	Force          bool
+	DryRun         bool
+}
+
+func GetCleanupCliCommand() cli.Command {
+	return cli.Command{
+		Name:  "cleanup",
+		Usage: "Remove helper pods, snapshots and snapshot clones left by interrupted runs",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:   "kubeconfig",
+				EnvVar: "KUBECONFIG",
+				Usage:  "Path to kubeconfig file",
+				Value:  path.Join(homedir.HomeDir(), ".kube", "config"),
+			},
+			cli.StringSliceFlag{
+				Name:  "namespaces,n",
+				Usage: "Remove objects only in this namespaces. By default all",
+			},
+			cli.DurationFlag{
+				Name:  "older-than",
+				Usage: "Remove only objects created earlier than this duration ago, so objects of running backups are kept. Required unless --force is set",
+			},
+			cli.BoolFlag{
+				Name:  "force",
+				Usage: "Remove objects of any age without --older-than, including objects of running backups",
+			},
+			cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Only print objects which would be removed",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			return Cleanup(&CleanupCommandArgs{
+				Kubeconfig:     c.String("kubeconfig"),
+				OnlyNamespaces: c.StringSlice("namespaces"),
+				OlderThan:      c.Duration("older-than"),
+				Force:          c.Bool("force"),
+				DryRun:         c.Bool("dry-run"),
+			})
+		},
+	}
+}
+
+func Cleanup(cfg *CleanupCommandArgs) error {
+	var err error
+
+	if cfg.OlderThan <= 0 && !cfg.Force {
+		return errors.New("--older-than is required, so objects of running backups are kept. Use --force to remove all")
+	}
+
+	ctx := context.Background()
+
+	err = k8s.InitClient(cfg.Kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	selector := labels.SelectorFromSet(labels.Set{helperLabel: helperLabelValue}).String()
+
+	leftovers, err := findLeftovers(ctx, selector)
+	if err != nil {
+		return err
+	}
+
+	threshold := time.Now().Add(-cfg.OlderThan)
+	failed := 0
+
+	for _, obj := range leftovers {
+		if !k8s.IsIncluded(obj.namespace, cfg.OnlyNamespaces, nil) {
+			continue
+		}
+
+		if obj.created.After(threshold) {
+			slog.Info("Keeping recent object", "kind", obj.kind, "name", obj.name, "namespace", obj.namespace)
+			continue
+		}
+
+		if cfg.DryRun {
+			slog.Info("Would remove object", "kind", obj.kind, "name", obj.name, "namespace", obj.namespace)
+			continue
+		}
+
+		err = obj.delete()
+		if err != nil && !apierrors.IsNotFound(err) {
+			slog.Error("Cannot remove object", "kind", obj.kind, "name", obj.name, "namespace", obj.namespace, "error", err)
+			failed++
+			continue
+		}
+
+		slog.Info("Object removed", "kind", obj.kind, "name", obj.name, "namespace", obj.namespace)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("cannot remove %d objects", failed)
+	}
+
+	return nil
+}
+
+// leftover is an object created by kubedump run
+type leftover struct {
+	kind      string
+	name      string
+	namespace string
+	created   time.Time
+	delete    func() error
+}
+
+// findLeftovers lists helper pods, snapshot clones and snapshots. Pods go first, so clones are not in use when removed
+func findLeftovers(ctx context.Context, selector string) ([]leftover, error) {
+	var leftovers []leftover
+
+	pods, err := k8s.KClient.CoreV1().
+		Pods(metav1.NamespaceAll).
+		List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pod := range pods.Items {
+		pod := pod
+		leftovers = append(leftovers, leftover{
+			kind:      "pod",
+			name:      pod.Name,
+			namespace: pod.Namespace,
+			created:   pod.CreationTimestamp.Time,
+			delete: func() error {
+				return deletePod(&pod)
+			},
+		})
+	}
+
+	claims, err := k8s.KClient.CoreV1().
+		PersistentVolumeClaims(metav1.NamespaceAll).
+		List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, claim := range claims.Items {
+		claim := claim
+		leftovers = append(leftovers, leftover{
+			kind:      "pvc",
+			name:      claim.Name,
+			namespace: claim.Namespace,
+			created:   claim.CreationTimestamp.Time,
+			delete: func() error {
+				return (&volumeSnapshot{namespace: claim.Namespace, clone: claim.Name}).deleteClone(ctx)
+			},
+		})
+	}
+
+	snapshots, err := k8s.KDynClient.
+		Resource(volumeSnapshotResource).
+		Namespace(metav1.NamespaceAll).
+		List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	if snapshots != nil {
+		for _, snapshot := range snapshots.Items {
+			snapshot := snapshot
+			leftovers = append(leftovers, leftover{
+				kind:      "volumesnapshot",
+				name:      snapshot.GetName(),
+				namespace: snapshot.GetNamespace(),
+				created:   snapshot.GetCreationTimestamp().Time,
+				delete: func() error {
+					return (&volumeSnapshot{namespace: snapshot.GetNamespace(), snapshot: snapshot.GetName()}).deleteSnapshot(ctx)
+				},
+			})
+		}
+	}
+
+	return leftovers, nil
+}
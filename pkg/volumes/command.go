@@ -1,9 +1,13 @@
 package volumes
 
 import (
+	"filippo.io/age"
+	"github.com/ThunderAl197/kubedump/pkg/output"
+	"github.com/ThunderAl197/kubedump/pkg/progress"
 	"github.com/urfave/cli"
 	"k8s.io/client-go/util/homedir"
 	"path"
+	"time"
 )
 
 type CommandArgs struct {
@@ -15,13 +19,225 @@ type CommandArgs struct {
 	DryRun            bool
 	IgnoreUnbound     bool
 	Threads           int
+	SkipPreflight     bool
+	Progress          string
+	MeasureSize       bool
+	HelperPod         HelperPodConfig
+	Compression       string
+	CompressionLevel  int
+	CompressLocally   bool
+
+	EncryptRecipients     []string
+	EncryptRecipientsFile string
+	EncryptPassphrase     string
+
+	Snapshot        bool
+	SnapshotClass   string
+	SnapshotTimeout time.Duration
+
+	Output output.Config
+
+	recipients []age.Recipient
+	output     output.Backend
+}
+
+// compressionStages returns compression done in helper pod and compression done on received tar stream
+func (c *CommandArgs) compressionStages() (string, string) {
+	if c.CompressLocally {
+		return CompressionNone, c.Compression
+	}
+
+	return c.Compression, CompressionNone
+}
+
+// compressedRemotely reports if received stream is compressed, so its size cannot be compared with volume size
+func (c *CommandArgs) compressedRemotely() bool {
+	remote, _ := c.compressionStages()
+	return remote != CompressionNone
+}
+
+func getCliFlags() []cli.Flag {
+	return append([]cli.Flag{
+		cli.StringFlag{
+			Name:   "kubeconfig",
+			EnvVar: "KUBECONFIG",
+			Usage:  "Path to kubeconfig file",
+			Value:  path.Join(homedir.HomeDir(), ".kube", "config"),
+		},
+		cli.StringFlag{
+			Name:  "output,o",
+			Usage: "Path to dist directory or s3://bucket/prefix",
+			Value: "./out",
+		},
+		cli.StringSliceFlag{
+			Name:  "namespaces,n",
+			Usage: "Download volumes which has pvc/pod in this namespaces. By default all",
+		},
+		cli.StringSliceFlag{
+			Name:  "exclude-namespaces,N",
+			Usage: "Exclude volumes which has pvc/pod in this namespaces. Can work with --namespaces",
+		},
+		cli.IntFlag{
+			Name:  "threads,t",
+			Usage: "Number of threads to download volumes. By default 3",
+			Value: 3,
+		},
+		cli.BoolFlag{
+			Name:  "ignore-unbound",
+			Usage: "Ignore unbound volumes",
+		},
+		cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "Only discover volumes and print them",
+		},
+		cli.BoolFlag{
+			Name:  "skip-preflight",
+			Usage: "Dont check permissions before downloading",
+		},
+		cli.StringFlag{
+			Name:  "progress",
+			Usage: "Progress display: auto, bar, plain or none. Auto uses bars on terminal",
+			Value: progress.ModeAuto,
+		},
+		cli.BoolFlag{
+			Name:  "measure-size",
+			Usage: "Measure volume size with du before download for precise ETA. By default pv capacity is used. Remotely compressed volumes have no ETA",
+		},
+		cli.BoolFlag{
+			Name:  "keep-partial",
+			Usage: "Keep archive of failed download with .partial suffix instead of removing it. Only for local output",
+		},
+		cli.StringFlag{
+			Name:  "compression",
+			Usage: "Archive compression: gzip, zstd or none. Default image has no zstd, use it with --compress-locally or custom --pod-image",
+			Value: CompressionGzip,
+		},
+		cli.IntFlag{
+			Name:  "compression-level",
+			Usage: "Compression level. By default 4 for gzip and 3 for zstd",
+		},
+		cli.BoolFlag{
+			Name:  "compress-locally",
+			Usage: "Compress archive here instead of helper pod, so pod image needs no compressor and pod cpu is spared",
+		},
+		cli.StringSliceFlag{
+			Name:  "encrypt-recipient",
+			Usage: "Encrypt archives with age for this public key",
+		},
+		cli.StringFlag{
+			Name:  "encrypt-recipients-file",
+			Usage: "Encrypt archives with age for public keys listed in this file",
+		},
+		cli.StringFlag{
+			Name:   "encrypt-passphrase",
+			EnvVar: "KUBEDUMP_ENCRYPTION_PASSPHRASE",
+			Usage:  "Encrypt archives with age using passphrase. Prefer env variable to keep it out of process list",
+		},
+		cli.BoolFlag{
+			Name:  "snapshot",
+			Usage: "Archive temporary pvc restored from csi volume snapshot instead of live pvc. Falls back to live pvc if volume cannot be snapshotted",
+		},
+		cli.StringFlag{
+			Name:  "snapshot-class",
+			Usage: "Volume snapshot class. By default class matching csi driver of the volume is used",
+		},
+		cli.DurationFlag{
+			Name:  "snapshot-timeout",
+			Usage: "Time to wait for snapshot to become ready and its clone to be bound",
+			Value: 10 * time.Minute,
+		},
+	}, output.GetCliFlags()...)
+}
+
+func getCommandArgs(c *cli.Context) *CommandArgs {
+	return &CommandArgs{
+		Kubeconfig:        c.String("kubeconfig"),
+		OutputDir:         c.String("output"),
+		OnlyNamespaces:    c.StringSlice("namespaces"),
+		ExcludeNamespaces: c.StringSlice("exclude-namespaces"),
+		Resources:         c.Args(),
+		Threads:           c.Int("threads"),
+		IgnoreUnbound:     c.Bool("ignore-unbound"),
+		DryRun:            c.Bool("dry-run"),
+		SkipPreflight:     c.Bool("skip-preflight"),
+		Progress:          c.String("progress"),
+		MeasureSize:       c.Bool("measure-size"),
+		HelperPod:         getHelperPodConfig(c),
+		Compression:       c.String("compression"),
+		CompressionLevel:  c.Int("compression-level"),
+		CompressLocally:   c.Bool("compress-locally"),
+
+		EncryptRecipients:     c.StringSlice("encrypt-recipient"),
+		EncryptRecipientsFile: c.String("encrypt-recipients-file"),
+		EncryptPassphrase:     c.String("encrypt-passphrase"),
+
+		Snapshot:        c.Bool("snapshot"),
+		SnapshotClass:   c.String("snapshot-class"),
+		SnapshotTimeout: c.Duration("snapshot-timeout"),
+
+		Output: getOutputConfig(c),
+	}
+}
+
+func getOutputConfig(c *cli.Context) output.Config {
+	cfg := output.GetConfig(c)
+	cfg.KeepPartial = c.Bool("keep-partial")
+
+	return cfg
 }
 
 func GetCliCommand() cli.Command {
+	return cli.Command{
+		Name:      "volumes",
+		Usage:     "Download cluster volumes",
+		Flags:     append(getCliFlags(), getHelperPodFlags()...),
+		ArgsUsage: "pv/pvc names. if its empty - all",
+		Action: func(c *cli.Context) error {
+			return Download(getCommandArgs(c))
+		},
+	}
+}
+
+func GetCheckCliCommand() cli.Command {
 	return cli.Command{
 		Name:  "volumes",
-		Usage: "Download cluster volumes",
-		Flags: []cli.Flag{
+		Usage: "Check permissions required to download cluster volumes",
+		Flags: append(getCliFlags(),
+			cli.BoolFlag{
+				Name:  "cluster-role",
+				Usage: "Print minimal ClusterRole granting required permissions",
+			},
+		),
+		ArgsUsage: "pv/pvc names. if its empty - all",
+		Action: func(c *cli.Context) error {
+			return Check(getCommandArgs(c), c.Bool("cluster-role"))
+		},
+	}
+}
+
+type RestoreCommandArgs struct {
+	Kubeconfig      string
+	InputDir        string
+	Resources       []string
+	TargetNamespace string
+	StorageClass    string
+	Size            string
+	Wipe            bool
+	AllowNonEmpty   bool
+	DryRun          bool
+	Progress        string
+	HelperPod       HelperPodConfig
+
+	IdentityFile      string
+	DecryptPassphrase string
+}
+
+func GetRestoreCliCommand() cli.Command {
+	return cli.Command{
+		Name:      "volumes",
+		Usage:     "Restore downloaded volumes into pvcs",
+		ArgsUsage: "pv/pvc names. if its empty - all",
+		Flags: append([]cli.Flag{
 			cli.StringFlag{
 				Name:   "kubeconfig",
 				EnvVar: "KUBECONFIG",
@@ -29,43 +245,65 @@ func GetCliCommand() cli.Command {
 				Value:  path.Join(homedir.HomeDir(), ".kube", "config"),
 			},
 			cli.StringFlag{
-				Name:  "output,o",
-				Usage: "Path to dist directory",
+				Name:  "input,i",
+				Usage: "Path to directory with downloaded volumes",
 				Value: "./out",
 			},
-			cli.StringSliceFlag{
-				Name:  "namespaces,n",
-				Usage: "Download volumes which has pvc/pod in this namespaces. By default all",
+			cli.StringFlag{
+				Name:  "target-namespace",
+				Usage: "Create pvcs in this namespace instead of original one",
 			},
-			cli.StringSliceFlag{
-				Name:  "exclude-namespaces,N",
-				Usage: "Exclude volumes which has pvc/pod in this namespaces. Can work with --namespaces",
+			cli.StringFlag{
+				Name:  "storage-class",
+				Usage: "Override storage class of created pvcs",
+			},
+			cli.StringFlag{
+				Name:  "size",
+				Usage: "Override requested size of created pvcs, e.g. 10Gi",
 			},
-			cli.IntFlag{
-				Name:  "threads,t",
-				Usage: "Number of threads to download volumes. By default 3",
-				Value: 3,
+			cli.BoolFlag{
+				Name:  "wipe",
+				Usage: "Remove existing files from target volume before restore",
 			},
 			cli.BoolFlag{
-				Name:  "ignore-unbound",
-				Usage: "Ignore unbound volumes",
+				Name:  "allow-non-empty",
+				Usage: "Extract archive over existing files. By default non-empty volumes are refused",
 			},
 			cli.BoolFlag{
 				Name:  "dry-run",
-				Usage: "Only discover volumes and print them",
+				Usage: "Only find volumes and print them",
 			},
-		},
-		ArgsUsage: "pv/pvc names. if its empty - all",
+			cli.StringFlag{
+				Name:  "progress",
+				Usage: "Progress display: auto, bar, plain or none. Auto uses bars on terminal",
+				Value: progress.ModeAuto,
+			},
+			cli.StringFlag{
+				Name:  "identity-file",
+				Usage: "Decrypt archives with age identities from this file",
+			},
+			cli.StringFlag{
+				Name:   "decrypt-passphrase",
+				EnvVar: "KUBEDUMP_ENCRYPTION_PASSPHRASE",
+				Usage:  "Decrypt archives encrypted with passphrase",
+			},
+		}, getHelperPodFlags()...),
 		Action: func(c *cli.Context) error {
-			return Download(&CommandArgs{
-				Kubeconfig:        c.String("kubeconfig"),
-				OutputDir:         c.String("output"),
-				OnlyNamespaces:    c.StringSlice("namespaces"),
-				ExcludeNamespaces: c.StringSlice("exclude-namespaces"),
-				Resources:         c.Args(),
-				Threads:           c.Int("threads"),
-				IgnoreUnbound:     c.Bool("ignore-unbound"),
-				DryRun:            c.Bool("dry-run"),
+			return Restore(&RestoreCommandArgs{
+				Kubeconfig:      c.String("kubeconfig"),
+				InputDir:        c.String("input"),
+				Resources:       c.Args(),
+				TargetNamespace: c.String("target-namespace"),
+				StorageClass:    c.String("storage-class"),
+				Size:            c.String("size"),
+				Wipe:            c.Bool("wipe"),
+				AllowNonEmpty:   c.Bool("allow-non-empty"),
+				DryRun:          c.Bool("dry-run"),
+				Progress:        c.String("progress"),
+				HelperPod:       getHelperPodConfig(c),
+
+				IdentityFile:      c.String("identity-file"),
+				DecryptPassphrase: c.String("decrypt-passphrase"),
 			})
 		},
 	}
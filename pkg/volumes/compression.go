@@ -0,0 +1,124 @@
+package volumes
+
+import (
+	"compress/gzip"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"strings"
+)
+
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+	CompressionNone = "none"
+)
+
+// archiveExtensions are archive file suffixes of every compression, longest first
+var archiveExtensions = []struct {
+	extension   string
+	compression string
+}{
+	{".tar.gz", CompressionGzip},
+	{".tar.zst", CompressionZstd},
+	{".tar", CompressionNone},
+}
+
+func archiveExtension(compression string) string {
+	for _, e := range archiveExtensions {
+		if e.compression == compression {
+			return e.extension
+		}
+	}
+
+	return ".tar"
+}
+
+// parseArchiveName returns pv name and compression of archive file
+func parseArchiveName(fileName string) (string, string, bool) {
+	for _, e := range archiveExtensions {
+		if pv, ok := strings.CutSuffix(fileName, e.extension); ok {
+			return pv, e.compression, true
+		}
+	}
+
+	return "", "", false
+}
+
+func validateCompression(compression string, level int) error {
+	switch compression {
+	case CompressionGzip:
+		if level < 0 || level > gzip.BestCompression {
+			return fmt.Errorf("gzip compression level must be between 1 and %d", gzip.BestCompression)
+		}
+	case CompressionZstd:
+		if level < 0 || level > 22 {
+			return fmt.Errorf("zstd compression level must be between 1 and 22")
+		}
+	case CompressionNone:
+	default:
+		return fmt.Errorf("unknown compression %q", compression)
+	}
+
+	return nil
+}
+
+// remoteCompressCommand is a shell pipeline stage compressing tar stream in helper pod
+func remoteCompressCommand(compression string, level int) string {
+	switch compression {
+	case CompressionGzip:
+		if level == 0 {
+			level = 4
+		}
+		return fmt.Sprintf(" | gzip -%dcf", level)
+	case CompressionZstd:
+		if level == 0 {
+			level = 3
+		}
+		return fmt.Sprintf(" | zstd -%d -c -q", level)
+	}
+
+	return ""
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// newCompressor compresses tar stream locally. Close flushes compressor, but does not close w
+func newCompressor(w io.Writer, compression string, level int) (io.WriteCloser, error) {
+	switch compression {
+	case CompressionGzip:
+		if level == 0 {
+			level = 4
+		}
+		return gzip.NewWriterLevel(w, level)
+	case CompressionZstd:
+		if level == 0 {
+			level = 3
+		}
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	}
+
+	return nopWriteCloser{w}, nil
+}
+
+// newDecompressor reads tar stream from archive
+func newDecompressor(r io.Reader, compression string) (io.ReadCloser, error) {
+	switch compression {
+	case CompressionGzip:
+		return gzip.NewReader(r)
+	case CompressionZstd:
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+
+	return io.NopCloser(r), nil
+}
@@ -5,15 +5,50 @@ import (
 	"fmt"
 	"github.com/Jeffail/tunny"
 	"github.com/ThunderAl197/kubedump/pkg/k8s"
+	"github.com/ThunderAl197/kubedump/pkg/metrics"
+	"github.com/ThunderAl197/kubedump/pkg/output"
+	"github.com/ThunderAl197/kubedump/pkg/progress"
+	"github.com/ThunderAl197/kubedump/pkg/report"
 	"golang.org/x/sync/errgroup"
-	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path"
 	"strings"
+	"syscall"
+	"time"
 )
 
 func Download(cfg *CommandArgs) error {
 	var err error
 
-	ctx := context.Background()
+	// cancel on interrupt, so helper pods are removed before exit
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	tracker, err := progress.New(cfg.Progress)
+	if err != nil {
+		return err
+	}
+
+	err = cfg.HelperPod.Load()
+	if err != nil {
+		return err
+	}
+
+	err = validateCompression(cfg.Compression, cfg.CompressionLevel)
+	if err != nil {
+		return err
+	}
+
+	if cfg.MeasureSize && cfg.compressedRemotely() {
+		slog.Warn("Volume size is not measured, progress of remotely compressed volumes has no total. Use --compress-locally for ETA")
+	}
+
+	cfg.recipients, err = cfg.encryptionRecipients()
+	if err != nil {
+		return err
+	}
 
 	err = k8s.InitClient(cfg.Kubeconfig)
 	if err != nil {
@@ -21,10 +56,22 @@ func Download(cfg *CommandArgs) error {
 	}
 
 	discovery, err := DiscoverVolumes(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	summary := NewSummary()
+
+	if !cfg.SkipPreflight && !cfg.DryRun {
+		err = preflightVolumes(ctx, cfg, discovery, summary)
+		if err != nil {
+			slog.Warn("Permissions preflight failed", "error", err)
+		}
+	}
 
 	for name, vol := range discovery {
 		if vol == nil {
-			log.Printf("Volume %s skipped\n", name)
+			slog.Info("Volume skipped", "pv", name)
 			continue
 		}
 
@@ -46,7 +93,7 @@ func Download(cfg *CommandArgs) error {
 			resources = append(resources, "no resources")
 		}
 
-		log.Printf("Volume %s pvc/%s mounted to %s\n", vol.pv.Name, vol.pvc.Name, strings.Join(resources, ", "))
+		slog.Info("Volume discovered", "pv", vol.pv.Name, "pvc", vol.pvc.Name, "namespace", vol.pvc.Namespace, "mounted", strings.Join(resources, ", "))
 	}
 
 	if cfg.DryRun {
@@ -61,18 +108,75 @@ func Download(cfg *CommandArgs) error {
 		count++
 	}
 
-	log.Printf("Downloading %d volumes with %d threads\n\n", count, cfg.Threads)
+	slog.Info("Downloading volumes", "count", count, "threads", cfg.Threads)
+
+	tasks := make(map[*VolumeDiscovery]*progress.Task)
+	for _, vol := range discovery {
+		if vol == nil {
+			continue
+		}
+
+		// capacity is an upper bound, --measure-size replaces it with real usage
+		total := vol.pv.Spec.Capacity.Storage().Value()
+		if cfg.compressedRemotely() {
+			total = 0
+		}
+
+		tasks[vol] = tracker.Task(vol.pv.Name, total, progress.UnitBytes)
+	}
+
+	tracker.Start()
+	defer tracker.Stop()
+
+	cfg.output, err = output.New(ctx, cfg.OutputDir, cfg.Output)
+	if err != nil {
+		return err
+	}
+
+	index, err := LoadIndex(cfg.output)
+	if err != nil {
+		return err
+	}
 
 	pool := tunny.NewFunc(cfg.Threads, func(payload interface{}) interface{} {
 		vol := payload.(*VolumeDiscovery)
 
-		log.Printf("Downloading volume %s\n", vol.pv.Name)
-		downloader := NewDownloader(vol)
-		err = downloader.Download(ctx, cfg)
+		slog.Info("Downloading volume", "pv", vol.pv.Name)
+		startedAt := time.Now()
+		downloader := NewDownloader(vol, tasks[vol])
+		err := downloader.Download(ctx, cfg)
+
+		duration := time.Since(startedAt)
+		metrics.VolumeDuration.Observe(duration.Seconds())
+
+		summary.Add(volumeResult{
+			pv:       vol.pv.Name,
+			pvc:      fmt.Sprintf("%s/%s", vol.pvc.Namespace, vol.pvc.Name),
+			bytes:    downloader.bytes,
+			duration: duration,
+			err:      err,
+			warnings: downloader.warnings,
+		})
+
 		if err != nil {
+			metrics.VolumesDownloaded.WithLabelValues("failed").Inc()
+			metrics.Errors.WithLabelValues("volumes").Inc()
 			return err
 		}
 
+		index.Set(&IndexEntry{
+			PV:           vol.pv.Name,
+			Namespace:    vol.pvc.Namespace,
+			PVC:          vol.pvc.Name,
+			Archive:      path.Base(downloader.archive),
+			Compression:  cfg.Compression,
+			Encrypted:    len(cfg.recipients) > 0,
+			Bytes:        downloader.bytes,
+			DownloadedAt: startedAt.UTC(),
+		})
+
+		metrics.VolumesDownloaded.WithLabelValues("success").Inc()
+
 		return nil
 	})
 	defer pool.Close()
@@ -84,11 +188,11 @@ func Download(cfg *CommandArgs) error {
 			continue
 		}
 
-		v := &*vol
+		v := vol
 		g.Go(func() error {
 			payload := pool.Process(v)
 			if payload != nil {
-				log.Printf("Fail to download %s: %s", v.pv.Name, payload.(error))
+				slog.Error("Cannot download volume", "pv", v.pv.Name, "error", payload.(error))
 			}
 
 			return nil
@@ -100,6 +204,29 @@ func Download(cfg *CommandArgs) error {
 		return err
 	}
 
-	return nil
+	tracker.Stop()
+
+	if len(index.Volumes) > 0 {
+		err = index.Write(cfg.output)
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Println()
+	err = summary.Print(os.Stdout)
+	if err != nil {
+		return err
+	}
+
+	failed, total := summary.Failed()
+	if failed > 0 && failed == total {
+		return fmt.Errorf("all %d volume downloads failed", total)
+	}
+
+	if failed > 0 {
+		return report.ErrPartial
+	}
 
+	return nil
 }
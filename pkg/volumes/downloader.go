@@ -2,67 +2,113 @@ package volumes
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"github.com/ThunderAl197/kubedump/pkg/k8s"
+	"github.com/ThunderAl197/kubedump/pkg/metrics"
+	"github.com/ThunderAl197/kubedump/pkg/progress"
+	"io"
 	v1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes/scheme"
-	"k8s.io/client-go/tools/remotecommand"
-	"log"
-	"os"
+	"log/slog"
 	"sigs.k8s.io/yaml"
-	"time"
+	"strconv"
+	"strings"
 )
 
 type Downloader struct {
 	discovery *VolumeDiscovery
+	progress  *progress.Task
+	// archive is path of the written archive and bytes is its size
+	archive string
+	bytes   int64
+	// warnings is stderr of successful remote tar
+	warnings string
 }
 
-func NewDownloader(discovery *VolumeDiscovery) *Downloader {
-	return &Downloader{discovery: discovery}
+func NewDownloader(discovery *VolumeDiscovery, progress *progress.Task) *Downloader {
+	return &Downloader{discovery: discovery, progress: progress}
 }
 
 func (d *Downloader) Download(ctx context.Context, cfg *CommandArgs) error {
 	var err error
 
-	pod, err := d.spawnPod(ctx, cfg)
+	d.progress.Start()
+	defer d.progress.Finish()
+
+	// snapshot clone is archived instead of live pvc, so data is consistent
+	claim := d.discovery.pvc.Name
+	live := true
+	if cfg.Snapshot {
+		snapshot, err := createSnapshot(ctx, cfg, d.discovery.pv, d.discovery.pvc)
+		if snapshot != nil {
+			defer snapshot.delete()
+		}
+
+		switch {
+		case errors.Is(err, errSnapshotUnsupported):
+			slog.Warn("Cannot snapshot volume, downloading live volume", "pv", d.discovery.pv.Name, "reason", err)
+		case err != nil:
+			return err
+		default:
+			claim = snapshot.clone
+			live = false
+		}
+	}
+
+	pod, err := d.spawnPod(ctx, cfg, claim, live)
 	if err != nil {
 		return err
 	}
 
-	log.Printf("Pod %s spawned\n", pod.Name)
+	slog.Info("Pod spawned", "pod", pod.Name, "namespace", pod.Namespace)
 	defer func() {
-		err = d.deletePod(ctx, pod)
+		err := deletePod(pod)
 		if err != nil {
-			log.Printf("Error deleting pod %s: %s\n", pod.Name, err)
+			slog.Error("Cannot delete pod", "pod", pod.Name, "namespace", pod.Namespace, "error", err)
 		}
 	}()
 
-	log.Printf("Waiting for pod %s to be ready\n", pod.Name)
-	err = d.waitPodReady(ctx, pod)
+	slog.Info("Waiting for pod to be ready", "pod", pod.Name, "namespace", pod.Namespace)
+	err = waitPodReady(ctx, pod, cfg.HelperPod.Timeout)
 	if err != nil {
 		return err
 	}
 
-	log.Printf("Downloading volume %s with tar exec\n", d.discovery.pv.Name)
+	if cfg.MeasureSize && !cfg.compressedRemotely() {
+		size, err := d.measureSize(ctx, pod)
+		if err != nil {
+			slog.Warn("Cannot measure volume size", "pv", d.discovery.pv.Name, "error", err)
+		} else {
+			d.progress.SetTotal(size)
+		}
+	}
+
+	slog.Info("Downloading volume with tar exec", "pv", d.discovery.pv.Name, "pod", pod.Name)
 	err = d.downloadWithTar(ctx, pod, cfg)
 	if err != nil {
 		return err
 	}
 
-	log.Printf("Volume %s downloaded\n", d.discovery.pv.Name)
+	slog.Info("Volume downloaded", "pv", d.discovery.pv.Name)
 
 	return nil
 }
 
-func (d *Downloader) spawnPod(ctx context.Context, cfg *CommandArgs) (*v1.Pod, error) {
+// spawnPod runs helper pod mounting claim. Pod of live claim is scheduled next to the volume
+func (d *Downloader) spawnPod(ctx context.Context, cfg *CommandArgs, claim string, live bool) (*v1.Pod, error) {
 	podName := fmt.Sprintf("kubedump-%s", d.discovery.pv.Name)
 
 	podAffinity := &v1.Affinity{}
 
-	if d.discovery.pv.Spec.NodeAffinity != nil {
+	if !live {
+
+		// clone is provisioned where pod is scheduled
+		podAffinity = nil
+
+	} else if d.discovery.pv.Spec.NodeAffinity != nil {
 
 		podAffinity.NodeAffinity = &v1.NodeAffinity{
 			RequiredDuringSchedulingIgnoredDuringExecution: d.discovery.pv.Spec.NodeAffinity.Required,
@@ -91,53 +137,10 @@ func (d *Downloader) spawnPod(ctx context.Context, cfg *CommandArgs) (*v1.Pod, e
 		podAffinity = nil
 	}
 
-	gracePeriod := int64(0)
-
-	pod := &v1.Pod{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      podName,
-			Namespace: d.discovery.pvc.Namespace,
-		},
-		Spec: v1.PodSpec{
-			Affinity:                      podAffinity,
-			RestartPolicy:                 v1.RestartPolicyNever,
-			TerminationGracePeriodSeconds: &gracePeriod,
-			Containers: []v1.Container{
-				{
-					Name:    "kubedump",
-					Image:   "debian:bookworm",
-					Command: []string{"tail", "-f", "/dev/null"},
-					VolumeMounts: []v1.VolumeMount{
-						{
-							Name:      "vol",
-							MountPath: "/mnt/vol",
-							ReadOnly:  true,
-						},
-					},
-					Resources: v1.ResourceRequirements{
-						Limits: v1.ResourceList{
-							"cpu":    resource.MustParse("500m"),
-							"memory": resource.MustParse("1000Mi"),
-						},
-						Requests: v1.ResourceList{
-							"cpu":    resource.MustParse("0m"),
-							"memory": resource.MustParse("0Mi"),
-						},
-					},
-				},
-			},
-			Volumes: []v1.Volume{
-				{
-					Name: "vol",
-					VolumeSource: v1.VolumeSource{
-						PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
-							ClaimName: d.discovery.pvc.Name,
-							ReadOnly:  true,
-						},
-					},
-				},
-			},
-		},
+	pod := newHelperPod(podName, d.discovery.pvc.Namespace, claim, true, podAffinity)
+	err := cfg.HelperPod.apply(pod)
+	if err != nil {
+		return nil, err
 	}
 
 	createdPod, err := k8s.KClient.CoreV1().
@@ -150,66 +153,15 @@ func (d *Downloader) spawnPod(ctx context.Context, cfg *CommandArgs) (*v1.Pod, e
 	return createdPod, nil
 }
 
-func (d *Downloader) isPodReady(ctx context.Context, pod *v1.Pod) (bool, error) {
-	pod, err := k8s.KClient.CoreV1().
-		Pods(pod.Namespace).
-		Get(ctx, pod.Name, metav1.GetOptions{})
-	if err != nil {
-		return false, err
-	}
-
-	if pod.Status.Phase != v1.PodRunning {
-		return false, nil
-	}
-
-	return true, nil
-}
-
-func (d *Downloader) waitPodReady(ctx context.Context, pod *v1.Pod) error {
-	threshold := time.Now().Add(time.Minute * 5)
-
-	for {
-		ready, err := d.isPodReady(ctx, pod)
-		if err != nil {
-			return err
-		}
-
-		if ready {
-			return nil
-		}
-
-		if time.Now().After(threshold) {
-			return fmt.Errorf("pod %s not ready after 5 min", pod.Name)
-		}
-	}
-}
-
-func (d *Downloader) deletePod(ctx context.Context, pod *v1.Pod) error {
-	err := k8s.KClient.CoreV1().
-		Pods(pod.Namespace).
-		Delete(ctx, pod.Name, metav1.DeleteOptions{})
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
 func (d *Downloader) downloadWithTar(ctx context.Context, pod *v1.Pod, cfg *CommandArgs) error {
 
 	var (
-		err      error
-		command  = []string{"bash", "-c", "((tar -cf - -C /mnt/vol .; dd if=/dev/zero of=/dev/stdout bs=1024 count=1024 status=none) | gzip -4cf) && sleep 5 || echo error >&2"}
-		destDir  = fmt.Sprintf("%s/volumes", cfg.OutputDir)
-		destFile = fmt.Sprintf("%s/%s.tar.gz", destDir, d.discovery.pv.Name)
+		err       error
+		remote, _ = cfg.compressionStages()
+		command   = []string{"bash", "-c", tarCommand(remote, cfg.CompressionLevel)}
+		destFile  = fmt.Sprintf("volumes/%s%s", d.discovery.pv.Name, archiveExtension(cfg.Compression))
 	)
 
-	// create output dir
-	err = os.MkdirAll(destDir, 0700)
-	if err != nil {
-		return err
-	}
-
 	// save pv manifest
 	{
 		pv := d.discovery.pv.DeepCopy()
@@ -219,8 +171,7 @@ func (d *Downloader) downloadWithTar(ctx context.Context, pod *v1.Pod, cfg *Comm
 			return err
 		}
 
-		fileName := fmt.Sprintf("%s/%s.yaml", destDir, d.discovery.pv.Name)
-		err = os.WriteFile(fileName, manifestData, 0600)
+		err = cfg.output.WriteFile(fmt.Sprintf("volumes/%s.yaml", d.discovery.pv.Name), manifestData)
 		if err != nil {
 			return err
 		}
@@ -235,65 +186,126 @@ func (d *Downloader) downloadWithTar(ctx context.Context, pod *v1.Pod, cfg *Comm
 			return err
 		}
 
-		fileName := fmt.Sprintf("%s/volumes/%s-pvc.yaml", cfg.OutputDir, d.discovery.pv.Name)
-		err = os.WriteFile(fileName, manifestData, 0600)
+		err = cfg.output.WriteFile(fmt.Sprintf("volumes/%s-pvc.yaml", d.discovery.pv.Name), manifestData)
 		if err != nil {
 			return err
 		}
 	}
 
-	// create archive file
-	archiveFile, err := os.OpenFile(destFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if len(cfg.recipients) > 0 {
+		destFile += encryptedExtension
+	}
+
+	archiveFile, err := cfg.output.Create(destFile)
 	if err != nil {
 		return err
 	}
 
-	writer := bufio.NewWriter(archiveFile)
-
-	// stream tar+gzip to file
-	req := k8s.KClient.CoreV1().
-		RESTClient().
-		Post().
-		Resource("pods").
-		Name(pod.Name).
-		Namespace(pod.Namespace).
-		SubResource("exec").
-		VersionedParams(
-			&v1.PodExecOptions{
-				Command:   command,
-				Container: "kubedump",
-				Stdout:    true,
-				Stderr:    true,
-				Stdin:     false,
-				TTY:       false,
-			},
-			scheme.ParameterCodec,
-		)
+	counter := &countingWriter{writer: archiveFile}
+
+	err = d.streamArchive(ctx, pod, command, counter, cfg)
+	if err != nil {
+		if abortErr := archiveFile.Abort(); abortErr != nil {
+			slog.Error("Cannot remove partial archive", "pv", d.discovery.pv.Name, "error", abortErr)
+		}
+
+		return err
+	}
+
+	err = archiveFile.Close()
+	if err != nil {
+		return err
+	}
+
+	d.archive = destFile
+	d.bytes = counter.bytes
+	metrics.BytesWritten.WithLabelValues("volumes").Add(float64(d.bytes))
+
+	return nil
+}
+
+type countingWriter struct {
+	writer io.Writer
+	bytes  int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// tarCommand archives the volume in helper pod. GNU tar exits with 1 when files change while
+// they are read, which is usual for live volumes, so only greater codes and compressor failures fail
+func tarCommand(remote string, level int) string {
+	command := "tar -cf - -C " + helperMountPath + " ."
+
+	compress := remoteCompressCommand(remote, level)
+	if compress == "" {
+		return command + `; s=$?; [ "$s" -le 1 ] || exit "$s"`
+	}
+
+	return command + compress + `; s=("${PIPESTATUS[@]}"); [ "${s[1]}" -eq 0 ] || exit "${s[1]}"; [ "${s[0]}" -le 1 ] || exit "${s[0]}"`
+}
+
+// streamArchive writes output of remote command to file and fails if command exits with non-zero code
+func (d *Downloader) streamArchive(ctx context.Context, pod *v1.Pod, command []string, file io.Writer, cfg *CommandArgs) error {
+	var (
+		err      error
+		stderr   bytes.Buffer
+		writer   = bufio.NewWriter(file)
+		_, local = cfg.compressionStages()
+	)
+
+	// data is encrypted right before it reaches the file
+	encryptor, err := newEncryptor(writer, cfg.recipients)
+	if err != nil {
+		return err
+	}
 
-	exec, err := remotecommand.NewSPDYExecutor(k8s.KConfig, "POST", req.URL())
+	compressor, err := newCompressor(encryptor, local, cfg.CompressionLevel)
 	if err != nil {
 		return err
 	}
 
-	err = exec.StreamWithContext(ctx, remotecommand.StreamOptions{
-		Stdin:  nil,
-		Stdout: writer,
-		Stderr: os.Stderr,
-		Tty:    false,
-	})
+	// progress counts received bytes. Remotely compressed stream cannot be compared with volume size,
+	// so its task has no total
+	err = execInPod(ctx, pod, command, nil, io.MultiWriter(compressor, d.progress), &stderr)
+	if err != nil {
+		return execError(err, &stderr)
+	}
+
+	err = compressor.Close()
 	if err != nil {
 		return err
 	}
 
-	err = writer.Flush()
+	err = encryptor.Close()
 	if err != nil {
 		return err
 	}
 
-	err = archiveFile.Close()
+	if stderr.Len() > 0 {
+		d.warnings = strings.TrimSpace(stderr.String())
+		slog.Warn("Remote tar reported warnings", "pv", d.discovery.pv.Name, "stderr", d.warnings)
+	}
+
+	return writer.Flush()
+}
+
+// measureSize returns size of volume files in bytes
+func (d *Downloader) measureSize(ctx context.Context, pod *v1.Pod) (int64, error) {
+	var stdout, stderr bytes.Buffer
+
+	err := execInPod(ctx, pod, []string{"du", "-sb", helperMountPath}, nil, &stdout, &stderr)
 	if err != nil {
-		log.Printf("Error closing archive file: %s\n", err)
+		return 0, execError(err, &stderr)
 	}
 
-	return nil
+	fields := strings.Fields(stdout.String())
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("unexpected du output %q", stdout.String())
+	}
+
+	return strconv.ParseInt(fields[0], 10, 64)
 }
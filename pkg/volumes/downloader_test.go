@@ -0,0 +1,58 @@
+package volumes
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// fakeTool writes script which reads stdin, prints its name and exits with code from env variable
+func fakeTool(t *testing.T, dir, name, codeEnv string) {
+	t.Helper()
+
+	script := "#!/bin/sh\ncat >/dev/null; echo " + name + "\nexit ${" + codeEnv + ":-0}\n"
+
+	err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTarCommandExitCodes(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash is not installed")
+	}
+
+	dir := t.TempDir()
+	fakeTool(t, dir, "tar", "TAR_EXIT")
+	fakeTool(t, dir, "gzip", "GZIP_EXIT")
+
+	tests := []struct {
+		name        string
+		compression string
+		tarExit     string
+		gzipExit    string
+		fail        bool
+	}{
+		{"tar ok", CompressionNone, "0", "0", false},
+		{"file changed", CompressionNone, "1", "0", false},
+		{"tar failed", CompressionNone, "2", "0", true},
+		{"compressed file changed", CompressionGzip, "1", "0", false},
+		{"compressed tar failed", CompressionGzip, "2", "0", true},
+		{"compressor failed", CompressionGzip, "0", "1", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := exec.Command(bash, "-c", tarCommand(tt.compression, 0))
+			cmd.Env = []string{"PATH=" + dir + string(os.PathListSeparator) + os.Getenv("PATH"), "TAR_EXIT=" + tt.tarExit, "GZIP_EXIT=" + tt.gzipExit}
+
+			err := cmd.Run()
+			if (err != nil) != tt.fail {
+				t.Errorf("got error %v, want failure %v", err, tt.fail)
+			}
+		})
+	}
+}
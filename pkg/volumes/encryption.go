@@ -0,0 +1,115 @@
+package volumes
+
+import (
+	"errors"
+	"filippo.io/age"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// encryptedExtension is appended to name of encrypted archive
+const encryptedExtension = ".age"
+
+// encryptionRecipients returns age recipients of archives, nil means archives are not encrypted
+func (c *CommandArgs) encryptionRecipients() ([]age.Recipient, error) {
+	var recipients []age.Recipient
+
+	if c.EncryptPassphrase != "" {
+		if len(c.EncryptRecipients) > 0 || c.EncryptRecipientsFile != "" {
+			return nil, errors.New("--encrypt-passphrase cannot be used with recipients")
+		}
+
+		recipient, err := age.NewScryptRecipient(c.EncryptPassphrase)
+		if err != nil {
+			return nil, err
+		}
+
+		return []age.Recipient{recipient}, nil
+	}
+
+	for _, r := range c.EncryptRecipients {
+		recipient, err := age.ParseX25519Recipient(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipient %q: %w", r, err)
+		}
+
+		recipients = append(recipients, recipient)
+	}
+
+	if c.EncryptRecipientsFile != "" {
+		file, err := os.Open(c.EncryptRecipientsFile)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		fileRecipients, err := age.ParseRecipients(file)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse recipients file: %w", err)
+		}
+
+		recipients = append(recipients, fileRecipients...)
+	}
+
+	return recipients, nil
+}
+
+// decryptionIdentities returns age identities for encrypted archives
+func (c *RestoreCommandArgs) decryptionIdentities() ([]age.Identity, error) {
+	var identities []age.Identity
+
+	if c.DecryptPassphrase != "" {
+		identity, err := age.NewScryptIdentity(c.DecryptPassphrase)
+		if err != nil {
+			return nil, err
+		}
+
+		identities = append(identities, identity)
+	}
+
+	if c.IdentityFile != "" {
+		file, err := os.Open(c.IdentityFile)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		fileIdentities, err := age.ParseIdentities(file)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse identity file: %w", err)
+		}
+
+		identities = append(identities, fileIdentities...)
+	}
+
+	return identities, nil
+}
+
+// newEncryptor encrypts archive stream if recipients are set. Close finishes encryption, but does not close w
+func newEncryptor(w io.Writer, recipients []age.Recipient) (io.WriteCloser, error) {
+	if len(recipients) == 0 {
+		return nopWriteCloser{w}, nil
+	}
+
+	return age.Encrypt(w, recipients...)
+}
+
+// newDecryptor decrypts archive stream if it is encrypted
+func newDecryptor(r io.Reader, encrypted bool, identities []age.Identity) (io.Reader, error) {
+	if !encrypted {
+		return r, nil
+	}
+
+	if len(identities) == 0 {
+		return nil, errors.New("archive is encrypted, use --identity-file or --decrypt-passphrase")
+	}
+
+	return age.Decrypt(r, identities...)
+}
+
+// trimEncryptedExtension removes .age suffix and reports if it was present
+func trimEncryptedExtension(fileName string) (string, bool) {
+	return strings.CutSuffix(fileName, encryptedExtension)
+}
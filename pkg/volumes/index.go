@@ -0,0 +1,95 @@
+package volumes
+
+import (
+	"errors"
+	"github.com/ThunderAl197/kubedump/pkg/output"
+	"os"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"sync"
+	"time"
+)
+
+const indexFile = "volumes/index.yaml"
+
+// Index describes downloaded archives, so restore knows how to read them. It is written to volumes/index.yaml
+type Index struct {
+	mu      sync.Mutex
+	Volumes []*IndexEntry `json:"volumes"`
+}
+
+type IndexEntry struct {
+	PV           string    `json:"pv"`
+	Namespace    string    `json:"namespace"`
+	PVC          string    `json:"pvc"`
+	Archive      string    `json:"archive"`
+	Compression  string    `json:"compression"`
+	Encrypted    bool      `json:"encrypted,omitempty"`
+	Bytes        int64     `json:"bytes"`
+	DownloadedAt time.Time `json:"downloadedAt"`
+}
+
+// LoadIndex reads index of volumes directory. Missing index is empty, archives of older versions have none
+func LoadIndex(backend output.Backend) (*Index, error) {
+	index := &Index{}
+
+	data, err := backend.ReadFile(indexFile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return index, nil
+		}
+
+		return nil, err
+	}
+
+	err = yaml.Unmarshal(data, index)
+	if err != nil {
+		return nil, err
+	}
+
+	return index, nil
+}
+
+// Set adds entry or replaces entry of the same volume
+func (i *Index) Set(entry *IndexEntry) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	for n, e := range i.Volumes {
+		if e.PV == entry.PV {
+			i.Volumes[n] = entry
+			return
+		}
+	}
+
+	i.Volumes = append(i.Volumes, entry)
+}
+
+func (i *Index) Get(pv string) *IndexEntry {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	for _, e := range i.Volumes {
+		if e.PV == pv {
+			return e
+		}
+	}
+
+	return nil
+}
+
+func (i *Index) Write(backend output.Backend) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	sort.Slice(i.Volumes, func(a, b int) bool {
+		return i.Volumes[a].PV < i.Volumes[b].PV
+	})
+
+	data, err := yaml.Marshal(i)
+	if err != nil {
+		return err
+	}
+
+	return backend.WriteFile(indexFile, data)
+}
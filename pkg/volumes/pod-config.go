@@ -0,0 +1,360 @@
+package volumes
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/urfave/cli"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"os"
+	"sigs.k8s.io/yaml"
+	"strings"
+	"time"
+)
+
+const defaultHelperImage = "debian:bookworm"
+
+// HelperPodConfig customizes helper pod. Pod template is merged first, so flags take precedence over it
+type HelperPodConfig struct {
+	Image            string
+	ImagePullSecrets []string
+	CpuRequest       string
+	CpuLimit         string
+	MemoryRequest    string
+	MemoryLimit      string
+	Tolerations      []string
+	NodeSelector     []string
+	PriorityClass    string
+	ServiceAccount   string
+	Labels           []string
+	Annotations      []string
+	RunAsUser        int64
+	Restricted       bool
+	TemplateFile     string
+	// Timeout limits waiting for helper pod to become ready
+	Timeout time.Duration
+
+	template []byte
+}
+
+func getHelperPodFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:  "pod-image",
+			Usage: "Helper pod image, it must provide bash, tar, du, find and gzip or zstd unless --compress-locally is used. By default " + defaultHelperImage,
+		},
+		cli.StringSliceFlag{
+			Name:  "pod-image-pull-secret",
+			Usage: "Image pull secret of helper pod",
+		},
+		cli.StringFlag{
+			Name:  "pod-cpu-request",
+			Usage: "Helper pod cpu request",
+		},
+		cli.StringFlag{
+			Name:  "pod-cpu-limit",
+			Usage: "Helper pod cpu limit. By default 500m",
+		},
+		cli.StringFlag{
+			Name:  "pod-memory-request",
+			Usage: "Helper pod memory request",
+		},
+		cli.StringFlag{
+			Name:  "pod-memory-limit",
+			Usage: "Helper pod memory limit. By default 1000Mi",
+		},
+		cli.StringSliceFlag{
+			Name:  "pod-toleration",
+			Usage: "Helper pod toleration in form key[=value][:effect]. Toleration without value uses Exists operator",
+		},
+		cli.StringSliceFlag{
+			Name:  "pod-node-selector",
+			Usage: "Helper pod node selector in form key=value",
+		},
+		cli.StringFlag{
+			Name:  "pod-priority-class",
+			Usage: "Helper pod priority class name",
+		},
+		cli.StringFlag{
+			Name:  "pod-service-account",
+			Usage: "Helper pod service account name",
+		},
+		cli.StringSliceFlag{
+			Name:  "pod-label",
+			Usage: "Helper pod label in form key=value",
+		},
+		cli.StringSliceFlag{
+			Name:  "pod-annotation",
+			Usage: "Helper pod annotation in form key=value",
+		},
+		cli.Int64Flag{
+			Name:  "pod-run-as-user",
+			Usage: "Run helper pod as this user id. By default image user is used",
+			Value: -1,
+		},
+		cli.BoolFlag{
+			Name:  "pod-restricted",
+			Usage: "Set security context of helper pod compatible with restricted pod security standard. Requires non-root image or --pod-run-as-user",
+		},
+		cli.DurationFlag{
+			Name:  "pod-timeout",
+			Usage: "Time to wait for helper pod to become ready",
+			Value: 5 * time.Minute,
+		},
+		cli.StringFlag{
+			Name:  "pod-template",
+			Usage: "Path to yaml pod manifest merged into helper pod with strategic merge patch. Helper container is named kubedump",
+		},
+	}
+}
+
+func getHelperPodConfig(c *cli.Context) HelperPodConfig {
+	return HelperPodConfig{
+		Image:            c.String("pod-image"),
+		ImagePullSecrets: c.StringSlice("pod-image-pull-secret"),
+		CpuRequest:       c.String("pod-cpu-request"),
+		CpuLimit:         c.String("pod-cpu-limit"),
+		MemoryRequest:    c.String("pod-memory-request"),
+		MemoryLimit:      c.String("pod-memory-limit"),
+		Tolerations:      c.StringSlice("pod-toleration"),
+		NodeSelector:     c.StringSlice("pod-node-selector"),
+		PriorityClass:    c.String("pod-priority-class"),
+		ServiceAccount:   c.String("pod-service-account"),
+		Labels:           c.StringSlice("pod-label"),
+		Annotations:      c.StringSlice("pod-annotation"),
+		RunAsUser:        c.Int64("pod-run-as-user"),
+		Restricted:       c.Bool("pod-restricted"),
+		TemplateFile:     c.String("pod-template"),
+		Timeout:          c.Duration("pod-timeout"),
+	}
+}
+
+// Load reads pod template and checks flag values, so mistakes are reported before any pod is created
+func (h *HelperPodConfig) Load() error {
+	if h.TemplateFile != "" {
+		data, err := os.ReadFile(h.TemplateFile)
+		if err != nil {
+			return err
+		}
+
+		h.template, err = yaml.YAMLToJSON(data)
+		if err != nil {
+			return fmt.Errorf("cannot parse pod template: %w", err)
+		}
+	}
+
+	return h.apply(newHelperPod("check", "default", "check", true, nil))
+}
+
+// apply merges pod template and flags into pod
+func (h *HelperPodConfig) apply(pod *v1.Pod) error {
+	if h.template != nil {
+		err := h.mergeTemplate(pod)
+		if err != nil {
+			return err
+		}
+	}
+
+	container := helperContainer(pod)
+	if container == nil {
+		return fmt.Errorf("pod template removed kubedump container")
+	}
+
+	if h.Image != "" {
+		container.Image = h.Image
+	}
+
+	for _, secret := range h.ImagePullSecrets {
+		pod.Spec.ImagePullSecrets = append(pod.Spec.ImagePullSecrets, v1.LocalObjectReference{Name: secret})
+	}
+
+	for _, q := range []struct {
+		value string
+		list  *v1.ResourceList
+		name  v1.ResourceName
+	}{
+		{h.CpuRequest, &container.Resources.Requests, v1.ResourceCPU},
+		{h.CpuLimit, &container.Resources.Limits, v1.ResourceCPU},
+		{h.MemoryRequest, &container.Resources.Requests, v1.ResourceMemory},
+		{h.MemoryLimit, &container.Resources.Limits, v1.ResourceMemory},
+	} {
+		if q.value == "" {
+			continue
+		}
+
+		quantity, err := resource.ParseQuantity(q.value)
+		if err != nil {
+			return fmt.Errorf("invalid %s quantity %q: %w", q.name, q.value, err)
+		}
+
+		if *q.list == nil {
+			*q.list = v1.ResourceList{}
+		}
+		(*q.list)[q.name] = quantity
+	}
+
+	for _, t := range h.Tolerations {
+		toleration, err := parseToleration(t)
+		if err != nil {
+			return err
+		}
+
+		pod.Spec.Tolerations = append(pod.Spec.Tolerations, toleration)
+	}
+
+	var err error
+
+	pod.Spec.NodeSelector, err = mergeKeyValues(pod.Spec.NodeSelector, h.NodeSelector)
+	if err != nil {
+		return err
+	}
+
+	pod.Labels, err = mergeKeyValues(pod.Labels, h.Labels)
+	if err != nil {
+		return err
+	}
+
+	if pod.Labels[helperLabel] != helperLabelValue {
+		return fmt.Errorf("label %s is reserved for cleanup of helper pods", helperLabel)
+	}
+
+	pod.Annotations, err = mergeKeyValues(pod.Annotations, h.Annotations)
+	if err != nil {
+		return err
+	}
+
+	if h.PriorityClass != "" {
+		pod.Spec.PriorityClassName = h.PriorityClass
+	}
+
+	if h.ServiceAccount != "" {
+		pod.Spec.ServiceAccountName = h.ServiceAccount
+	}
+
+	if h.RunAsUser >= 0 {
+		if pod.Spec.SecurityContext == nil {
+			pod.Spec.SecurityContext = &v1.PodSecurityContext{}
+		}
+		pod.Spec.SecurityContext.RunAsUser = &h.RunAsUser
+	}
+
+	if h.Restricted {
+		if pod.Spec.SecurityContext == nil {
+			pod.Spec.SecurityContext = &v1.PodSecurityContext{}
+		}
+
+		runAsNonRoot := true
+		allowPrivilegeEscalation := false
+
+		pod.Spec.SecurityContext.RunAsNonRoot = &runAsNonRoot
+		pod.Spec.SecurityContext.SeccompProfile = &v1.SeccompProfile{Type: v1.SeccompProfileTypeRuntimeDefault}
+
+		if container.SecurityContext == nil {
+			container.SecurityContext = &v1.SecurityContext{}
+		}
+		container.SecurityContext.AllowPrivilegeEscalation = &allowPrivilegeEscalation
+		container.SecurityContext.Capabilities = &v1.Capabilities{Drop: []v1.Capability{"ALL"}}
+	}
+
+	return nil
+}
+
+// mergeTemplate applies pod template, keeping parts of helper pod which kubedump relies on
+func (h *HelperPodConfig) mergeTemplate(pod *v1.Pod) error {
+	built := pod.DeepCopy()
+
+	original, err := json.Marshal(pod)
+	if err != nil {
+		return err
+	}
+
+	merged, err := strategicpatch.StrategicMergePatch(original, h.template, v1.Pod{})
+	if err != nil {
+		return fmt.Errorf("cannot merge pod template: %w", err)
+	}
+
+	*pod = v1.Pod{}
+	err = json.Unmarshal(merged, pod)
+	if err != nil {
+		return fmt.Errorf("cannot merge pod template: %w", err)
+	}
+
+	pod.Name = built.Name
+	pod.Namespace = built.Namespace
+	if pod.Labels == nil {
+		pod.Labels = make(map[string]string)
+	}
+	pod.Labels[helperLabel] = helperLabelValue
+
+	container := helperContainer(pod)
+	if container == nil {
+		return fmt.Errorf("pod template removed kubedump container")
+	}
+
+	container.Command = built.Spec.Containers[0].Command
+	replaceByName(container.VolumeMounts, built.Spec.Containers[0].VolumeMounts[0], func(m v1.VolumeMount) string { return m.Name })
+	replaceByName(pod.Spec.Volumes, built.Spec.Volumes[0], func(v v1.Volume) string { return v.Name })
+
+	return nil
+}
+
+// replaceByName overwrites item of the list with the same name
+func replaceByName[T any](list []T, item T, name func(T) string) {
+	for i := range list {
+		if name(list[i]) == name(item) {
+			list[i] = item
+		}
+	}
+}
+
+func helperContainer(pod *v1.Pod) *v1.Container {
+	for i := range pod.Spec.Containers {
+		if pod.Spec.Containers[i].Name == "kubedump" {
+			return &pod.Spec.Containers[i]
+		}
+	}
+
+	return nil
+}
+
+// parseToleration parses key[=value][:effect]
+func parseToleration(s string) (v1.Toleration, error) {
+	toleration := v1.Toleration{Operator: v1.TolerationOpExists}
+
+	rest, effect, ok := strings.Cut(s, ":")
+	if ok {
+		switch v1.TaintEffect(effect) {
+		case v1.TaintEffectNoSchedule, v1.TaintEffectPreferNoSchedule, v1.TaintEffectNoExecute:
+			toleration.Effect = v1.TaintEffect(effect)
+		default:
+			return toleration, fmt.Errorf("invalid toleration %q: unknown effect %q", s, effect)
+		}
+	}
+
+	key, value, ok := strings.Cut(rest, "=")
+	toleration.Key = key
+	if ok {
+		toleration.Operator = v1.TolerationOpEqual
+		toleration.Value = value
+	}
+
+	return toleration, nil
+}
+
+// mergeKeyValues adds key=value pairs to the map
+func mergeKeyValues(m map[string]string, pairs []string) (map[string]string, error) {
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid key=value pair %q", pair)
+		}
+
+		if m == nil {
+			m = make(map[string]string)
+		}
+		m[key] = value
+	}
+
+	return m, nil
+}
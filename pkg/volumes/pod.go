@@ -0,0 +1,325 @@
+package volumes
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ThunderAl197/kubedump/pkg/k8s"
+	"io"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/remotecommand"
+	watchtools "k8s.io/client-go/tools/watch"
+	"k8s.io/client-go/util/exec"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// helperMountPath is where helper pod mounts the volume
+const helperMountPath = "/mnt/vol"
+
+// helperLabel marks pods, snapshots and claims created by kubedump, so leftovers can be found by cleanup.
+// Generic managed-by label is not used, because other tools may set it too
+const (
+	helperLabel      = "kubedump.io/helper"
+	helperLabelValue = "true"
+)
+
+const cleanupTimeout = 30 * time.Second
+
+// cleanupContext is used to remove created objects. It does not inherit the run context,
+// so objects are removed even if the run was cancelled
+func cleanupContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), cleanupTimeout)
+}
+
+// newHelperPod builds pod which mounts claim and idles, so commands can be executed in it
+func newHelperPod(name, namespace, claimName string, readOnly bool, affinity *v1.Affinity) *v1.Pod {
+	gracePeriod := int64(0)
+
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels: map[string]string{
+				helperLabel: helperLabelValue,
+			},
+		},
+		Spec: v1.PodSpec{
+			Affinity:                      affinity,
+			RestartPolicy:                 v1.RestartPolicyNever,
+			TerminationGracePeriodSeconds: &gracePeriod,
+			Containers: []v1.Container{
+				{
+					Name:    "kubedump",
+					Image:   defaultHelperImage,
+					Command: []string{"tail", "-f", "/dev/null"},
+					VolumeMounts: []v1.VolumeMount{
+						{
+							Name:      "vol",
+							MountPath: helperMountPath,
+							ReadOnly:  readOnly,
+						},
+					},
+					Resources: v1.ResourceRequirements{
+						Limits: v1.ResourceList{
+							"cpu":    resource.MustParse("500m"),
+							"memory": resource.MustParse("1000Mi"),
+						},
+						Requests: v1.ResourceList{
+							"cpu":    resource.MustParse("0m"),
+							"memory": resource.MustParse("0Mi"),
+						},
+					},
+				},
+			},
+			Volumes: []v1.Volume{
+				{
+					Name: "vol",
+					VolumeSource: v1.VolumeSource{
+						PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+							ClaimName: claimName,
+							ReadOnly:  readOnly,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// podFailureReasons are container waiting reasons which will not resolve without user action
+var podFailureReasons = map[string]bool{
+	"ErrImagePull":               true,
+	"ImagePullBackOff":           true,
+	"InvalidImageName":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+}
+
+// unschedulableFailures are parts of scheduler messages which neither provisioning nor autoscaling resolve.
+// Other unschedulable pods, e.g. waiting for unbound claim or new node, are waited for
+var unschedulableFailures = []string{
+	"volume node affinity conflict",
+	// persistentvolumeclaim "name" not found or being deleted
+	"\" not found",
+	"\" is being deleted",
+}
+
+// unschedulableMessage returns scheduler message of pod which cannot be scheduled yet
+func unschedulableMessage(pod *v1.Pod) string {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodScheduled && condition.Status == v1.ConditionFalse && condition.Reason == v1.PodReasonUnschedulable {
+			return condition.Message
+		}
+	}
+
+	return ""
+}
+
+// checkPodReady returns true if all containers are ready and error if pod cannot become ready
+func checkPodReady(pod *v1.Pod) (bool, error) {
+	switch pod.Status.Phase {
+	case v1.PodFailed, v1.PodSucceeded:
+		return false, fmt.Errorf("pod %s terminated with phase %s: %s", pod.Name, pod.Status.Phase, pod.Status.Message)
+	}
+
+	if message := unschedulableMessage(pod); message != "" {
+		for _, failure := range unschedulableFailures {
+			if strings.Contains(message, failure) {
+				return false, fmt.Errorf("pod %s is unschedulable: %s", pod.Name, message)
+			}
+		}
+
+		return false, nil
+	}
+
+	// containers of pending pod wait for image, so image errors are checked before the phase
+	statuses := append(append([]v1.ContainerStatus(nil), pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if status.State.Waiting != nil && podFailureReasons[status.State.Waiting.Reason] {
+			return false, fmt.Errorf("container %s of pod %s failed: %s: %s", status.Name, pod.Name, status.State.Waiting.Reason, status.State.Waiting.Message)
+		}
+	}
+
+	if pod.Status.Phase != v1.PodRunning || len(pod.Status.ContainerStatuses) == 0 {
+		return false, nil
+	}
+
+	for _, status := range pod.Status.ContainerStatuses {
+		if !status.Ready {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// waitPodReady watches pod until its containers are ready, fails early on scheduling, image and volume attach errors
+func waitPodReady(ctx context.Context, pod *v1.Pod, timeout time.Duration) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	go watchPodEvents(ctx, pod, cancel)
+
+	watchCtx, cancelWatch := context.WithTimeout(ctx, timeout)
+	defer cancelWatch()
+
+	pods := k8s.KClient.CoreV1().Pods(pod.Namespace)
+	fieldSelector := fields.OneTermEqualSelector("metadata.name", pod.Name).String()
+
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = fieldSelector
+			return pods.List(watchCtx, options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = fieldSelector
+			return pods.Watch(watchCtx, options)
+		},
+	}
+
+	// last scheduler message is reported once, not on every pod update
+	pending := ""
+
+	_, err := watchtools.UntilWithSync(watchCtx, lw, &v1.Pod{}, nil, func(event watch.Event) (bool, error) {
+		switch event.Type {
+		case watch.Deleted:
+			return false, fmt.Errorf("pod %s was deleted", pod.Name)
+		case watch.Added, watch.Modified:
+			current := event.Object.(*v1.Pod)
+
+			if message := unschedulableMessage(current); message != "" && message != pending {
+				slog.Warn("Pod is not scheduled yet", "pod", pod.Name, "namespace", pod.Namespace, "message", message)
+			}
+			pending = unschedulableMessage(current)
+
+			return checkPodReady(current)
+		}
+
+		return false, nil
+	})
+	if err != nil {
+		if cause := context.Cause(ctx); cause != nil && !errors.Is(cause, context.Canceled) {
+			return cause
+		}
+
+		if errors.Is(watchCtx.Err(), context.DeadlineExceeded) {
+			if pending != "" {
+				return fmt.Errorf("pod %s not scheduled after %s: %s", pod.Name, timeout, pending)
+			}
+
+			return fmt.Errorf("pod %s not ready after %s", pod.Name, timeout)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// watchPodEvents cancels context when volume of the pod cannot be attached
+func watchPodEvents(ctx context.Context, pod *v1.Pod, cancel context.CancelCauseFunc) {
+	watcher, err := k8s.KClient.CoreV1().
+		Events(pod.Namespace).
+		Watch(ctx, metav1.ListOptions{
+			FieldSelector: fields.OneTermEqualSelector("involvedObject.uid", string(pod.UID)).String(),
+		})
+	if err != nil {
+		slog.Debug("Cannot watch pod events", "pod", pod.Name, "error", err)
+		return
+	}
+	defer watcher.Stop()
+
+	for event := range watcher.ResultChan() {
+		e, ok := event.Object.(*v1.Event)
+		if !ok {
+			continue
+		}
+
+		switch e.Reason {
+		case "FailedAttachVolume":
+			cancel(fmt.Errorf("volume of pod %s cannot be attached: %s", pod.Name, e.Message))
+			return
+		case "FailedMount":
+			// mount is retried by kubelet, so it may still succeed
+			slog.Warn("Pod volume mount failed", "pod", pod.Name, "namespace", pod.Namespace, "message", e.Message)
+		}
+	}
+}
+
+func deletePod(pod *v1.Pod) error {
+	ctx, cancel := cleanupContext()
+	defer cancel()
+
+	err := k8s.KClient.CoreV1().
+		Pods(pod.Namespace).
+		Delete(ctx, pod.Name, metav1.DeleteOptions{})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// execInPod runs command in helper container, stdin is optional
+func execInPod(ctx context.Context, pod *v1.Pod, command []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	req := k8s.KClient.CoreV1().
+		RESTClient().
+		Post().
+		Resource("pods").
+		Name(pod.Name).
+		Namespace(pod.Namespace).
+		SubResource("exec").
+		VersionedParams(
+			&v1.PodExecOptions{
+				Command:   command,
+				Container: "kubedump",
+				Stdout:    true,
+				Stderr:    true,
+				Stdin:     stdin != nil,
+				TTY:       false,
+			},
+			scheme.ParameterCodec,
+		)
+
+	exec, err := remotecommand.NewSPDYExecutor(k8s.KConfig, "POST", req.URL())
+	if err != nil {
+		return err
+	}
+
+	return exec.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdin:  stdin,
+		Stdout: stdout,
+		Stderr: stderr,
+		Tty:    false,
+	})
+}
+
+// execError adds remote exit code and stderr output to error of execInPod
+func execError(err error, stderr *bytes.Buffer) error {
+	output := strings.TrimSpace(stderr.String())
+
+	var exitErr exec.CodeExitError
+	if errors.As(err, &exitErr) {
+		if output == "" {
+			return fmt.Errorf("remote command exited with code %d", exitErr.Code)
+		}
+
+		return fmt.Errorf("remote command exited with code %d: %s", exitErr.Code, output)
+	}
+
+	if output == "" {
+		return err
+	}
+
+	return fmt.Errorf("%w: %s", err, output)
+}
@@ -0,0 +1,74 @@
+package volumes
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"testing"
+)
+
+func unschedulablePod(message string) *v1.Pod {
+	return &v1.Pod{
+		Status: v1.PodStatus{
+			Phase: v1.PodPending,
+			Conditions: []v1.PodCondition{{
+				Type:    v1.PodScheduled,
+				Status:  v1.ConditionFalse,
+				Reason:  v1.PodReasonUnschedulable,
+				Message: message,
+			}},
+		},
+	}
+}
+
+func TestCheckPodReadyUnschedulable(t *testing.T) {
+	tests := []struct {
+		message string
+		fail    bool
+	}{
+		{"0/3 nodes are available: pod has unbound immediate PersistentVolumeClaims.", false},
+		{"0/3 nodes are available: 3 Insufficient cpu.", false},
+		{"0/3 nodes are available: 3 node(s) had volume node affinity conflict.", true},
+		{`persistentvolumeclaim "data" not found`, true},
+		{`persistentvolumeclaim "data" is being deleted`, true},
+	}
+
+	for _, tt := range tests {
+		ready, err := checkPodReady(unschedulablePod(tt.message))
+		if ready {
+			t.Errorf("%q: unschedulable pod is ready", tt.message)
+		}
+
+		if (err != nil) != tt.fail {
+			t.Errorf("%q: got error %v, want failure %v", tt.message, err, tt.fail)
+		}
+	}
+}
+
+func TestCheckPodReadyImageFailure(t *testing.T) {
+	waiting := func(reason string) []v1.ContainerStatus {
+		return []v1.ContainerStatus{{
+			Name:  "kubedump",
+			State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: reason, Message: "pull failed"}},
+		}}
+	}
+
+	pending := &v1.Pod{Status: v1.PodStatus{Phase: v1.PodPending, ContainerStatuses: waiting("ErrImagePull")}}
+
+	_, err := checkPodReady(pending)
+	if err == nil {
+		t.Error("pending pod with ErrImagePull is not reported as failed")
+	}
+
+	initPending := &v1.Pod{Status: v1.PodStatus{Phase: v1.PodPending, InitContainerStatuses: waiting("ImagePullBackOff")}}
+
+	_, err = checkPodReady(initPending)
+	if err == nil {
+		t.Error("pending pod with ImagePullBackOff init container is not reported as failed")
+	}
+
+	creating := &v1.Pod{Status: v1.PodStatus{Phase: v1.PodPending, ContainerStatuses: waiting("ContainerCreating")}}
+
+	ready, err := checkPodReady(creating)
+	if ready || err != nil {
+		t.Errorf("creating pod: got ready %v, error %v, want waiting", ready, err)
+	}
+}
@@ -0,0 +1,183 @@
+package volumes
+
+import (
+	"context"
+	"fmt"
+	"github.com/ThunderAl197/kubedump/pkg/k8s"
+	"log/slog"
+	"os"
+	"sort"
+)
+
+func clusterAccessChecks() []k8s.AccessCheck {
+	return []k8s.AccessCheck{
+		{Verb: "list", Resource: "persistentvolumes"},
+		{Verb: "get", Resource: "persistentvolumes"},
+		{Verb: "list", Group: "storage.k8s.io", Resource: "volumeattachments"},
+	}
+}
+
+// discoveryAccessChecks are permissions used by DiscoverVolume to find volume consumers
+func discoveryAccessChecks(namespace string) []k8s.AccessCheck {
+	return []k8s.AccessCheck{
+		{Verb: "get", Resource: "persistentvolumeclaims", Namespace: namespace},
+		{Verb: "list", Resource: "pods", Namespace: namespace},
+		{Verb: "get", Group: "apps", Resource: "replicasets", Namespace: namespace},
+		{Verb: "get", Group: "apps", Resource: "deployments", Namespace: namespace},
+		{Verb: "get", Group: "apps", Resource: "statefulsets", Namespace: namespace},
+		{Verb: "get", Group: "apps", Resource: "daemonsets", Namespace: namespace},
+	}
+}
+
+// helperPodAccessChecks are permissions used by Downloader to run helper pod
+func helperPodAccessChecks(namespace string) []k8s.AccessCheck {
+	return []k8s.AccessCheck{
+		{Verb: "create", Resource: "pods", Namespace: namespace},
+		{Verb: "get", Resource: "pods", Namespace: namespace},
+		{Verb: "list", Resource: "pods", Namespace: namespace},
+		{Verb: "watch", Resource: "pods", Namespace: namespace},
+		{Verb: "delete", Resource: "pods", Namespace: namespace},
+		{Verb: "watch", Resource: "events", Namespace: namespace},
+		{Verb: "create", Resource: "pods", Subresource: "exec", Namespace: namespace},
+	}
+}
+
+// snapshotAccessChecks are permissions used to archive volume from snapshot
+func snapshotAccessChecks(namespace string) []k8s.AccessCheck {
+	return []k8s.AccessCheck{
+		{Verb: "create", Group: snapshotGroup, Resource: "volumesnapshots", Namespace: namespace},
+		{Verb: "get", Group: snapshotGroup, Resource: "volumesnapshots", Namespace: namespace},
+		{Verb: "delete", Group: snapshotGroup, Resource: "volumesnapshots", Namespace: namespace},
+		{Verb: "create", Resource: "persistentvolumeclaims", Namespace: namespace},
+		{Verb: "get", Resource: "persistentvolumeclaims", Namespace: namespace},
+		{Verb: "delete", Resource: "persistentvolumeclaims", Namespace: namespace},
+		{Verb: "get", Group: "storage.k8s.io", Resource: "storageclasses"},
+	}
+}
+
+func discoveryNamespaces(discovery map[string]*VolumeDiscovery) []string {
+	set := make(map[string]bool)
+	for _, vol := range discovery {
+		if vol == nil {
+			continue
+		}
+		set[vol.pvc.Namespace] = true
+	}
+
+	var namespaces []string
+	for ns := range set {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+
+	return namespaces
+}
+
+// preflightVolumes skips volumes in namespaces where helper pod cannot be used and records them as failed
+func preflightVolumes(ctx context.Context, cfg *CommandArgs, discovery map[string]*VolumeDiscovery, summary *Summary) error {
+	denied := make(map[string]string)
+
+	// checks of all namespaces are sent at once, so they run concurrently
+	var (
+		checks []k8s.AccessCheck
+		owners []string
+	)
+	for _, ns := range discoveryNamespaces(discovery) {
+		nsChecks := helperPodAccessChecks(ns)
+		if cfg.Snapshot {
+			nsChecks = append(nsChecks, snapshotAccessChecks(ns)...)
+		}
+
+		for _, check := range nsChecks {
+			checks = append(checks, check)
+			owners = append(owners, ns)
+		}
+	}
+
+	results, err := k8s.CheckAccess(ctx, checks)
+	if err != nil {
+		return err
+	}
+
+	for i, result := range results {
+		ns := owners[i]
+		if _, ok := denied[ns]; !ok && !result.Allowed {
+			denied[ns] = result.Check.String()
+		}
+	}
+
+	for name, vol := range discovery {
+		if vol == nil {
+			continue
+		}
+
+		if check, ok := denied[vol.pvc.Namespace]; ok {
+			slog.Warn("Skipping volume, permission denied", "pv", vol.pv.Name, "namespace", vol.pvc.Namespace, "denied", check)
+			summary.Add(volumeResult{
+				pv:  vol.pv.Name,
+				pvc: fmt.Sprintf("%s/%s", vol.pvc.Namespace, vol.pvc.Name),
+				err: fmt.Errorf("permission denied: %s", check),
+			})
+			discovery[name] = nil
+		}
+	}
+
+	return nil
+}
+
+func Check(cfg *CommandArgs, printClusterRole bool) error {
+	var err error
+
+	ctx := context.Background()
+
+	err = k8s.InitClient(cfg.Kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	checks := clusterAccessChecks()
+	if cfg.Snapshot {
+		checks = append(checks, k8s.AccessCheck{Verb: "list", Group: snapshotGroup, Resource: "volumesnapshotclasses"})
+	}
+
+	namespaces := cfg.OnlyNamespaces
+
+	discovery, err := DiscoverVolumes(ctx, cfg)
+	if err != nil {
+		slog.Warn("Cannot discover volumes", "error", err)
+	} else {
+		namespaces = discoveryNamespaces(discovery)
+	}
+
+	if len(namespaces) == 0 {
+		// check across all namespaces
+		namespaces = []string{""}
+	}
+
+	for _, ns := range namespaces {
+		checks = append(checks, discoveryAccessChecks(ns)...)
+		checks = append(checks, helperPodAccessChecks(ns)...)
+		if cfg.Snapshot {
+			checks = append(checks, snapshotAccessChecks(ns)...)
+		}
+	}
+
+	// keep stdout clean for the role manifest
+	out := os.Stdout
+	if printClusterRole {
+		role, err := k8s.ClusterRoleYaml("kubedump-volumes", checks)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(string(role))
+		out = os.Stderr
+	}
+
+	results, err := k8s.CheckAccess(ctx, checks)
+	if err != nil {
+		return err
+	}
+
+	return k8s.ReportAccess(out, results)
+}
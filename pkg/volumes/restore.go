@@ -0,0 +1,370 @@
+package volumes
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"filippo.io/age"
+	"fmt"
+	"github.com/ThunderAl197/kubedump/pkg/k8s"
+	"github.com/ThunderAl197/kubedump/pkg/output"
+	"github.com/ThunderAl197/kubedump/pkg/progress"
+	"io"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// restoreArchive is a downloaded volume found in the input directory
+type restoreArchive struct {
+	pv          string
+	archiveFile string
+	compression string
+	encrypted   bool
+	pvc         *v1.PersistentVolumeClaim
+}
+
+func Restore(cfg *RestoreCommandArgs) error {
+	var err error
+
+	if cfg.Wipe && cfg.AllowNonEmpty {
+		return errors.New("--wipe cannot be used with --allow-non-empty")
+	}
+
+	var size resource.Quantity
+	if cfg.Size != "" {
+		size, err = resource.ParseQuantity(cfg.Size)
+		if err != nil {
+			return fmt.Errorf("invalid size %q: %w", cfg.Size, err)
+		}
+	}
+
+	tracker, err := progress.New(cfg.Progress)
+	if err != nil {
+		return err
+	}
+
+	err = cfg.HelperPod.Load()
+	if err != nil {
+		return err
+	}
+
+	identities, err := cfg.decryptionIdentities()
+	if err != nil {
+		return err
+	}
+
+	archives, err := findArchives(cfg)
+	if err != nil {
+		return err
+	}
+
+	if len(archives) == 0 {
+		return fmt.Errorf("no volumes to restore found in %s", cfg.InputDir)
+	}
+
+	for _, archive := range archives {
+		if archive.encrypted && len(identities) == 0 {
+			return fmt.Errorf("archive of %s is encrypted, use --identity-file or --decrypt-passphrase", archive.pv)
+		}
+
+		archive.pvc = restoredClaim(archive.pvc, cfg, size)
+
+		slog.Info("Volume found", "pv", archive.pv, "pvc", archive.pvc.Name, "namespace", archive.pvc.Namespace, "archive", archive.archiveFile)
+	}
+
+	if cfg.DryRun {
+		return nil
+	}
+
+	// cancel on interrupt, so helper pods are removed before exit
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err = k8s.InitClient(cfg.Kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	tasks := make(map[*restoreArchive]*progress.Task)
+	for _, archive := range archives {
+		stat, err := os.Stat(archive.archiveFile)
+		if err != nil {
+			return err
+		}
+
+		tasks[archive] = tracker.Task(archive.pv, stat.Size(), progress.UnitBytes)
+	}
+
+	tracker.Start()
+	defer tracker.Stop()
+
+	for _, archive := range archives {
+		slog.Info("Restoring volume", "pv", archive.pv, "pvc", archive.pvc.Name, "namespace", archive.pvc.Namespace)
+
+		err = restoreVolume(ctx, cfg, archive, identities, tasks[archive])
+		if err != nil {
+			return fmt.Errorf("cannot restore volume %s: %w", archive.pv, err)
+		}
+
+		slog.Info("Volume restored", "pv", archive.pv)
+	}
+
+	return nil
+}
+
+// findArchives lists downloaded volumes which have both archive and pvc manifest
+func findArchives(cfg *RestoreCommandArgs) ([]*restoreArchive, error) {
+	dir := path.Join(cfg.InputDir, "volumes")
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := LoadIndex(output.NewLocal(cfg.InputDir, false))
+	if err != nil {
+		return nil, err
+	}
+
+	var archives []*restoreArchive
+
+	for _, entry := range entries {
+		name, encrypted := trimEncryptedExtension(entry.Name())
+
+		pv, compression, ok := parseArchiveName(name)
+		if !ok || entry.IsDir() {
+			continue
+		}
+
+		// index knows actual archive if volume was downloaded several times with different compression
+		if indexed := index.Get(pv); indexed != nil {
+			if indexed.Archive != entry.Name() {
+				continue
+			}
+			compression = indexed.Compression
+		}
+
+		data, err := os.ReadFile(path.Join(dir, pv+"-pvc.yaml"))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				slog.Warn("Skipping volume without pvc manifest", "pv", pv)
+				continue
+			}
+
+			return nil, err
+		}
+
+		pvc := &v1.PersistentVolumeClaim{}
+		err = yaml.Unmarshal(data, pvc)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse pvc manifest of %s: %w", pv, err)
+		}
+
+		if len(cfg.Resources) > 0 && !k8s.IsIncludedAny([]string{pv, pvc.Name}, cfg.Resources, nil) {
+			continue
+		}
+
+		archives = append(archives, &restoreArchive{
+			pv:          pv,
+			archiveFile: path.Join(dir, entry.Name()),
+			compression: compression,
+			encrypted:   encrypted,
+			pvc:         pvc,
+		})
+	}
+
+	sort.Slice(archives, func(i, j int) bool {
+		return archives[i].pv < archives[j].pv
+	})
+
+	return archives, nil
+}
+
+// restoredClaim strips binding state of saved claim and applies overrides, so it can be provisioned again
+func restoredClaim(saved *v1.PersistentVolumeClaim, cfg *RestoreCommandArgs, size resource.Quantity) *v1.PersistentVolumeClaim {
+	pvc := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        saved.Name,
+			Namespace:   saved.Namespace,
+			Labels:      saved.Labels,
+			Annotations: make(map[string]string),
+		},
+		Spec: *saved.Spec.DeepCopy(),
+	}
+
+	for key, value := range saved.Annotations {
+		// binding and provisioning annotations belong to the old volume
+		if strings.HasPrefix(key, "pv.kubernetes.io/") ||
+			strings.HasPrefix(key, "volume.kubernetes.io/") ||
+			strings.HasPrefix(key, "volume.beta.kubernetes.io/") ||
+			key == "kubectl.kubernetes.io/last-applied-configuration" {
+			continue
+		}
+
+		pvc.Annotations[key] = value
+	}
+
+	pvc.Spec.VolumeName = ""
+
+	if cfg.TargetNamespace != "" {
+		pvc.Namespace = cfg.TargetNamespace
+	}
+
+	if cfg.StorageClass != "" {
+		pvc.Spec.StorageClassName = &cfg.StorageClass
+	}
+
+	if !size.IsZero() {
+		if pvc.Spec.Resources.Requests == nil {
+			pvc.Spec.Resources.Requests = v1.ResourceList{}
+		}
+		pvc.Spec.Resources.Requests[v1.ResourceStorage] = size
+	}
+
+	return pvc
+}
+
+func restoreVolume(ctx context.Context, cfg *RestoreCommandArgs, archive *restoreArchive, identities []age.Identity, task *progress.Task) error {
+	var err error
+
+	task.Start()
+	defer task.Finish()
+
+	claim, err := k8s.KClient.CoreV1().
+		PersistentVolumeClaims(archive.pvc.Namespace).
+		Create(ctx, archive.pvc, metav1.CreateOptions{})
+	if err != nil {
+		if !apierrors.IsAlreadyExists(err) {
+			return err
+		}
+
+		slog.Info("Using existing pvc", "pvc", archive.pvc.Name, "namespace", archive.pvc.Namespace)
+
+		claim, err = k8s.KClient.CoreV1().
+			PersistentVolumeClaims(archive.pvc.Namespace).
+			Get(ctx, archive.pvc.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+	} else {
+		slog.Info("Pvc created", "pvc", archive.pvc.Name, "namespace", archive.pvc.Namespace)
+	}
+
+	// pod of unbound claim is unschedulable
+	err = waitClaimBound(ctx, claim, cfg.HelperPod.Timeout)
+	if err != nil {
+		return err
+	}
+
+	pod := newHelperPod(fmt.Sprintf("kubedump-restore-%s", archive.pvc.Name), archive.pvc.Namespace, archive.pvc.Name, false, nil)
+	err = cfg.HelperPod.apply(pod)
+	if err != nil {
+		return err
+	}
+
+	pod, err = k8s.KClient.CoreV1().
+		Pods(archive.pvc.Namespace).
+		Create(ctx, pod, metav1.CreateOptions{})
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Pod spawned", "pod", pod.Name, "namespace", pod.Namespace)
+	defer func() {
+		err := deletePod(pod)
+		if err != nil {
+			slog.Error("Cannot delete pod", "pod", pod.Name, "namespace", pod.Namespace, "error", err)
+		}
+	}()
+
+	slog.Info("Waiting for pod to be ready", "pod", pod.Name, "namespace", pod.Namespace)
+	err = waitPodReady(ctx, pod, cfg.HelperPod.Timeout)
+	if err != nil {
+		return err
+	}
+
+	empty, err := isVolumeEmpty(ctx, pod)
+	if err != nil {
+		return err
+	}
+
+	if !empty {
+		switch {
+		case cfg.Wipe:
+			slog.Info("Wiping volume", "pvc", archive.pvc.Name, "namespace", archive.pvc.Namespace)
+			err = runInPod(ctx, pod, "find "+helperMountPath+" -mindepth 1 -maxdepth 1 ! -name lost+found -exec rm -rf {} +")
+			if err != nil {
+				return err
+			}
+		case cfg.AllowNonEmpty:
+			slog.Warn("Restoring into non-empty volume", "pvc", archive.pvc.Name, "namespace", archive.pvc.Namespace)
+		default:
+			return fmt.Errorf("pvc %s/%s is not empty, use --wipe or --allow-non-empty", archive.pvc.Namespace, archive.pvc.Name)
+		}
+	}
+
+	archiveFile, err := os.Open(archive.archiveFile)
+	if err != nil {
+		return err
+	}
+	defer archiveFile.Close()
+
+	decryptor, err := newDecryptor(io.TeeReader(archiveFile, task), archive.encrypted, identities)
+	if err != nil {
+		return err
+	}
+
+	// archive is decompressed here, so helper pod needs only tar
+	decompressor, err := newDecompressor(decryptor, archive.compression)
+	if err != nil {
+		return err
+	}
+	defer decompressor.Close()
+
+	var stderr bytes.Buffer
+
+	err = execInPod(ctx, pod,
+		[]string{"tar", "-xpf", "-", "--numeric-owner", "-C", helperMountPath},
+		decompressor, io.Discard, &stderr)
+	if err != nil {
+		return execError(err, &stderr)
+	}
+
+	return nil
+}
+
+// isVolumeEmpty checks mounted volume has no files except lost+found
+func isVolumeEmpty(ctx context.Context, pod *v1.Pod) (bool, error) {
+	var stdout, stderr bytes.Buffer
+
+	err := execInPod(ctx, pod,
+		[]string{"find", helperMountPath, "-mindepth", "1", "-maxdepth", "1", "!", "-name", "lost+found"},
+		nil, &stdout, &stderr)
+	if err != nil {
+		return false, execError(err, &stderr)
+	}
+
+	return strings.TrimSpace(stdout.String()) == "", nil
+}
+
+// runInPod runs shell script in helper pod
+func runInPod(ctx context.Context, pod *v1.Pod, script string) error {
+	var stderr bytes.Buffer
+
+	err := execInPod(ctx, pod, []string{"sh", "-c", script}, nil, io.Discard, &stderr)
+	if err != nil {
+		return execError(err, &stderr)
+	}
+
+	return nil
+}
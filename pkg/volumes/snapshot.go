@@ -0,0 +1,263 @@
+package volumes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ThunderAl197/kubedump/pkg/k8s"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"log/slog"
+	"time"
+)
+
+const snapshotGroup = "snapshot.storage.k8s.io"
+
+var (
+	volumeSnapshotResource = schema.GroupVersionResource{
+		Group:    snapshotGroup,
+		Version:  "v1",
+		Resource: "volumesnapshots",
+	}
+	volumeSnapshotClassResource = schema.GroupVersionResource{
+		Group:    snapshotGroup,
+		Version:  "v1",
+		Resource: "volumesnapshotclasses",
+	}
+)
+
+const (
+	defaultSnapshotClassAnnotation = "snapshot.storage.kubernetes.io/is-default-class"
+	snapshotPollInterval           = 2 * time.Second
+)
+
+// errSnapshotUnsupported means volume cannot be snapshotted and live pvc should be used
+var errSnapshotUnsupported = errors.New("snapshots are not supported")
+
+// volumeSnapshot is a snapshot of downloaded pvc and a temporary pvc provisioned from it
+type volumeSnapshot struct {
+	namespace string
+	snapshot  string
+	clone     string
+}
+
+// findSnapshotClass returns snapshot class of csi driver, preferring the default one
+func findSnapshotClass(ctx context.Context, driver string) (string, error) {
+	list, err := k8s.KDynClient.
+		Resource(volumeSnapshotClassResource).
+		List(ctx, metav1.ListOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return "", fmt.Errorf("%w: snapshot api is not installed", errSnapshotUnsupported)
+		}
+
+		return "", err
+	}
+
+	found := ""
+	for _, class := range list.Items {
+		classDriver, _, _ := unstructured.NestedString(class.Object, "driver")
+		if classDriver != driver {
+			continue
+		}
+
+		if class.GetAnnotations()[defaultSnapshotClassAnnotation] == "true" {
+			return class.GetName(), nil
+		}
+
+		if found == "" {
+			found = class.GetName()
+		}
+	}
+
+	if found == "" {
+		return "", fmt.Errorf("%w: no snapshot class for driver %s", errSnapshotUnsupported, driver)
+	}
+
+	return found, nil
+}
+
+// createSnapshot snapshots pvc and provisions temporary pvc from it. Created objects are removed
+// by delete, even if error is returned
+func createSnapshot(ctx context.Context, cfg *CommandArgs, pv *v1.PersistentVolume, pvc *v1.PersistentVolumeClaim) (*volumeSnapshot, error) {
+	if pv.Spec.CSI == nil {
+		return nil, fmt.Errorf("%w: volume is not provisioned by csi driver", errSnapshotUnsupported)
+	}
+
+	class := cfg.SnapshotClass
+	if class == "" {
+		var err error
+		class, err = findSnapshotClass(ctx, pv.Spec.CSI.Driver)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	snapshot := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": snapshotGroup + "/v1",
+		"kind":       "VolumeSnapshot",
+		"metadata": map[string]interface{}{
+			"generateName": fmt.Sprintf("kubedump-%s-", pvc.Name),
+			"namespace":    pvc.Namespace,
+			"labels": map[string]interface{}{
+				helperLabel: helperLabelValue,
+			},
+		},
+		"spec": map[string]interface{}{
+			"volumeSnapshotClassName": class,
+			"source": map[string]interface{}{
+				"persistentVolumeClaimName": pvc.Name,
+			},
+		},
+	}}
+
+	snapshot, err := k8s.KDynClient.
+		Resource(volumeSnapshotResource).
+		Namespace(pvc.Namespace).
+		Create(ctx, snapshot, metav1.CreateOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("%w: snapshot api is not installed", errSnapshotUnsupported)
+		}
+
+		return nil, err
+	}
+
+	result := &volumeSnapshot{namespace: pvc.Namespace, snapshot: snapshot.GetName()}
+
+	slog.Info("Snapshot created", "snapshot", result.snapshot, "namespace", result.namespace, "class", class)
+
+	// timeout covers both the snapshot and provisioning of its clone
+	deadline := time.Now().Add(cfg.SnapshotTimeout)
+
+	restoreSize, err := waitSnapshotReady(ctx, result, cfg.SnapshotTimeout)
+	if err != nil {
+		return result, err
+	}
+
+	// clone must be at least as large as the snapshot
+	size := pvc.Spec.Resources.Requests[v1.ResourceStorage]
+	if restoreSize != nil && restoreSize.Cmp(size) > 0 {
+		size = *restoreSize
+	}
+
+	clone := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: fmt.Sprintf("kubedump-%s-", pvc.Name),
+			Namespace:    pvc.Namespace,
+			Labels: map[string]string{
+				helperLabel: helperLabelValue,
+			},
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes:      []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			StorageClassName: pvc.Spec.StorageClassName,
+			VolumeMode:       pvc.Spec.VolumeMode,
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{v1.ResourceStorage: size},
+			},
+			DataSource: &v1.TypedLocalObjectReference{
+				APIGroup: &volumeSnapshotResource.Group,
+				Kind:     "VolumeSnapshot",
+				Name:     result.snapshot,
+			},
+		},
+	}
+
+	clone, err = k8s.KClient.CoreV1().
+		PersistentVolumeClaims(pvc.Namespace).
+		Create(ctx, clone, metav1.CreateOptions{})
+	if err != nil {
+		return result, err
+	}
+
+	result.clone = clone.Name
+
+	slog.Info("Snapshot clone created", "pvc", result.clone, "namespace", result.namespace)
+
+	// helper pod of the clone is unschedulable until the clone is provisioned
+	err = waitClaimBound(ctx, clone, time.Until(deadline))
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// waitSnapshotReady polls snapshot until it can be used as data source and returns its size
+func waitSnapshotReady(ctx context.Context, snapshot *volumeSnapshot, timeout time.Duration) (*resource.Quantity, error) {
+	var restoreSize *resource.Quantity
+
+	err := wait.PollUntilContextTimeout(ctx, snapshotPollInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		obj, err := k8s.KDynClient.
+			Resource(volumeSnapshotResource).
+			Namespace(snapshot.namespace).
+			Get(ctx, snapshot.snapshot, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+
+		if message, found, _ := unstructured.NestedString(obj.Object, "status", "error", "message"); found {
+			return false, fmt.Errorf("snapshot %s failed: %s", snapshot.snapshot, message)
+		}
+
+		ready, _, _ := unstructured.NestedBool(obj.Object, "status", "readyToUse")
+		if !ready {
+			return false, nil
+		}
+
+		if size, found, _ := unstructured.NestedString(obj.Object, "status", "restoreSize"); found {
+			quantity, err := resource.ParseQuantity(size)
+			if err == nil {
+				restoreSize = &quantity
+			}
+		}
+
+		return true, nil
+	})
+	if err != nil {
+		if ctx.Err() == nil && wait.Interrupted(err) {
+			return nil, fmt.Errorf("snapshot %s not ready after %s", snapshot.snapshot, timeout)
+		}
+
+		return nil, err
+	}
+
+	return restoreSize, nil
+}
+
+// delete removes clone and snapshot
+func (s *volumeSnapshot) delete() {
+	ctx, cancel := cleanupContext()
+	defer cancel()
+
+	if s.clone != "" {
+		err := s.deleteClone(ctx)
+		if err != nil && !apierrors.IsNotFound(err) {
+			slog.Error("Cannot delete snapshot clone", "pvc", s.clone, "namespace", s.namespace, "error", err)
+		}
+	}
+
+	err := s.deleteSnapshot(ctx)
+	if err != nil && !apierrors.IsNotFound(err) {
+		slog.Error("Cannot delete snapshot", "snapshot", s.snapshot, "namespace", s.namespace, "error", err)
+	}
+}
+
+func (s *volumeSnapshot) deleteClone(ctx context.Context) error {
+	return k8s.KClient.CoreV1().
+		PersistentVolumeClaims(s.namespace).
+		Delete(ctx, s.clone, metav1.DeleteOptions{})
+}
+
+func (s *volumeSnapshot) deleteSnapshot(ctx context.Context) error {
+	return k8s.KDynClient.
+		Resource(volumeSnapshotResource).
+		Namespace(s.namespace).
+		Delete(ctx, s.snapshot, metav1.DeleteOptions{})
+}
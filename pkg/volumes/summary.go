@@ -0,0 +1,79 @@
+package volumes
+
+import (
+	"github.com/ThunderAl197/kubedump/pkg/progress"
+	"github.com/ThunderAl197/kubedump/pkg/report"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type volumeResult struct {
+	pv       string
+	pvc      string
+	bytes    int64
+	duration time.Duration
+	err      error
+	// warnings is stderr of remote tar which succeeded
+	warnings string
+}
+
+// Summary collects per-volume results of a download
+type Summary struct {
+	mu      sync.Mutex
+	results []volumeResult
+}
+
+func NewSummary() *Summary {
+	return &Summary{}
+}
+
+func (s *Summary) Add(result volumeResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.results = append(s.results, result)
+}
+
+// Failed returns number of failed and total volumes
+func (s *Summary) Failed() (int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	failed := 0
+	for _, r := range s.results {
+		if r.err != nil {
+			failed++
+		}
+	}
+
+	return failed, len(s.results)
+}
+
+func (s *Summary) Print(w io.Writer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sort.Slice(s.results, func(i, j int) bool {
+		return s.results[i].pv < s.results[j].pv
+	})
+
+	table := report.NewTable("VOLUME", "PVC", "BYTES", "DURATION", "STATUS", "WARNINGS")
+	for _, r := range s.results {
+		status := "ok"
+		if r.err != nil {
+			status = "failed: " + r.err.Error()
+		}
+
+		warnings := "-"
+		if r.warnings != "" {
+			warnings = strings.ReplaceAll(r.warnings, "\n", "; ")
+		}
+
+		table.Add(r.pv, r.pvc, progress.FormatBytes(r.bytes), r.duration.Truncate(time.Second).String(), status, warnings)
+	}
+
+	return table.Print(w)
+}